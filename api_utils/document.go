@@ -0,0 +1,26 @@
+package api_utils
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/SevereCloud/vksdk/v2/api"
+	"github.com/SevereCloud/vksdk/v2/api/params"
+)
+
+// UploadMessageDocument uploads a file as a document for a message to the peer,
+// using a `*api.VK` client with Community access. Returns the attachment string to send the document with.
+func UploadMessageDocument(vkCommunity *api.VK, peerID int, filename string, data []byte) (string, error) {
+	response, err := vkCommunity.UploadMessagesDoc(peerID, "doc", filename, "", bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("uploading document %s: %w", filename, err)
+	}
+	return response.Doc.ToAttachment(), nil
+}
+
+// CreateMessageSendBuilderDocument creates a message send builder with text and a document attachment.
+func CreateMessageSendBuilderDocument(text, attachment string) *params.MessagesSendBuilder {
+	msg := CreateMessageSendBuilderText(text)
+	msg.Attachment(attachment)
+	return msg
+}
@@ -0,0 +1,116 @@
+package api_utils
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/SevereCloud/vksdk/v2/object"
+)
+
+// Formats posts can be exported in.
+const (
+	ExportFormatCSV  = "csv"
+	ExportFormatJSON = "json"
+)
+
+// exportDateLayout is the publication date format of exported posts, readable by spreadsheet applications.
+const exportDateLayout = "2006-01-02 15:04:05"
+
+// PostExportRecord is a postponed post as exported for reporting.
+type PostExportRecord struct {
+	ID       int    `json:"id"`
+	OwnerID  int    `json:"owner_id"`
+	SignerID int    `json:"signer_id"`
+	Link     string `json:"link"`
+	// Publication date in the export timezone
+	Date       string `json:"date"`
+	Timestamp  int    `json:"timestamp"`
+	TextLength int    `json:"text_length"`
+	// Types of post attachments, in order of attachment
+	AttachmentTypes []string `json:"attachment_types"`
+	// Audios as "Artist - Title"
+	Audios []string `json:"audios"`
+}
+
+// csvHeader names columns of exported CSV files, in order of PostExportRecord fields.
+var csvHeader = []string{
+	"id", "owner_id", "signer_id", "link", "date", "timestamp", "text_length", "attachment_types", "audios",
+}
+
+// GetPostExportRecords converts posts into export records, sorted by publication date.
+// Dates are formatted in the given location.
+func GetPostExportRecords(posts []object.WallWallpost, loc *time.Location) []PostExportRecord {
+	records := make([]PostExportRecord, 0, len(posts))
+	for _, post := range posts {
+		record := PostExportRecord{
+			ID:              post.ID,
+			OwnerID:         post.OwnerID,
+			SignerID:        post.SignerID,
+			Link:            fmt.Sprintf("vk.com/wall%d_%d", post.OwnerID, post.ID),
+			Date:            time.Unix(int64(post.Date), 0).In(loc).Format(exportDateLayout),
+			Timestamp:       post.Date,
+			TextLength:      utf8.RuneCountInString(post.Text),
+			AttachmentTypes: []string{},
+			Audios:          []string{},
+		}
+		for _, attachment := range post.Attachments {
+			record.AttachmentTypes = append(record.AttachmentTypes, attachment.Type)
+		}
+		if postAudios, err := getPostAudios(post); err == nil {
+			for _, audio := range postAudios {
+				record.Audios = append(record.Audios, getAudioArtistTitle(audio))
+			}
+		}
+		records = append(records, record)
+	}
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Timestamp < records[j].Timestamp })
+	return records
+}
+
+// ExportPosts writes posts to `w` in the given format, with dates in the given location.
+// CSV files list attachment types and audios separated by "; ".
+func ExportPosts(w io.Writer, posts []object.WallWallpost, loc *time.Location, format string) error {
+	records := GetPostExportRecords(posts, loc)
+	switch format {
+	case ExportFormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(records)
+	case ExportFormatCSV:
+		// Byte order mark makes spreadsheet applications read the file as UTF-8
+		if _, err := io.WriteString(w, "\ufeff"); err != nil {
+			return err
+		}
+		writer := csv.NewWriter(w)
+		if err := writer.Write(csvHeader); err != nil {
+			return err
+		}
+		for _, record := range records {
+			err := writer.Write([]string{
+				strconv.Itoa(record.ID),
+				strconv.Itoa(record.OwnerID),
+				strconv.Itoa(record.SignerID),
+				record.Link,
+				record.Date,
+				strconv.Itoa(record.Timestamp),
+				strconv.Itoa(record.TextLength),
+				strings.Join(record.AttachmentTypes, "; "),
+				strings.Join(record.Audios, "; "),
+			})
+			if err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	default:
+		return fmt.Errorf("unknown export format %q", format)
+	}
+}
@@ -1,19 +1,25 @@
 package api_utils
 
 import (
+	"errors"
+	"fmt"
+
 	"github.com/SevereCloud/vksdk/v2/api"
-	"github.com/alphatoasterous/otlozhka-bot/logging"
+	"github.com/SevereCloud/vksdk/v2/object"
 )
 
 // GetGroupInfo retrieves information about the community/group page using a `*api.VK* instance with community access.
 // It makes an API call to vkCommunity.GroupsGetByID and returns the group information.
-// If an error occurs during the API call, the function logs the error and terminates execution.
-func GetGroupInfo(vkCommunity *api.VK) api.GroupsGetByIDResponse {
-	group, err := vkCommunity.GroupsGetByID(nil)
+// Returns an error if the API call fails or returns no groups.
+func GetGroupInfo(vkCommunity *api.VK) (object.GroupsGroup, error) {
+	groups, err := vkCommunity.GroupsGetByID(nil)
 	if err != nil {
-		logging.Log.Fatal().Err(err)
+		return object.GroupsGroup{}, fmt.Errorf("getting group info: %w", err)
+	}
+	if len(groups) == 0 {
+		return object.GroupsGroup{}, errors.New("getting group info: no group returned, check community token")
 	}
-	return group
+	return groups[0], nil
 }
 
 // IsManagerWithRights checks if a given role is associated with managerial rights.
@@ -33,12 +39,12 @@ func IsManagerWithRights(role string) bool {
 // GetGroupManagerIDs retrieves the IDs of group managers with managerial permissions from VK.
 // It uses the vkUser client to make an API call with the specified domain as the group_id.
 // The function filters the members list to include only those with managerial rights.
-// Returns a slice of IDs or logs a fatal error if the API call fails.
+// Returns a slice of IDs or an error if the API call fails.
 // It should be noted, that vkUser client should have sufficient permissions in given domain or else things go south.
-func GetGroupManagerIDs(vkUser *api.VK, domain string) []int {
+func GetGroupManagerIDs(vkUser *api.VK, domain string) ([]int, error) {
 	groupManagers, err := vkUser.GroupsGetMembersFilterManagers(api.Params{"group_id": domain})
 	if err != nil {
-		logging.Log.Fatal().Err(err)
+		return nil, fmt.Errorf("getting managers of %s: %w", domain, err)
 	}
 	groupManagerIDs := make([]int, 0, len(groupManagers.Items))
 	for _, groupManager := range groupManagers.Items {
@@ -46,5 +52,5 @@ func GetGroupManagerIDs(vkUser *api.VK, domain string) []int {
 			groupManagerIDs = append(groupManagerIDs, groupManager.ID)
 		}
 	}
-	return groupManagerIDs
+	return groupManagerIDs, nil
 }
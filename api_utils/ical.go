@@ -0,0 +1,109 @@
+package api_utils
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/SevereCloud/vksdk/v2/object"
+)
+
+// icalTimeLayout is the UTC date-time format of iCalendar (RFC 5545, section 3.3.5).
+const icalTimeLayout = "20060102T150405Z"
+
+// icalLineLimit is the maximum length of an iCalendar content line in octets, excluding the line break.
+const icalLineLimit = 75
+
+// icalExcerptLength is the maximum number of characters of post text included in event descriptions.
+const icalExcerptLength = 200
+
+// GetICalendar generates an iCalendar (RFC 5545) file with an event for every post, lasting `eventDuration`.
+// Events hold the post link, the author name from `authorNames` (mapped by signer ID), an excerpt of the post text
+// and audio titles. `now` is used as the event time stamp.
+func GetICalendar(posts []object.WallWallpost, authorNames map[int]string, calendarName string,
+	eventDuration time.Duration, now time.Time) []byte {
+	sortedPosts := make([]object.WallWallpost, len(posts))
+	copy(sortedPosts, posts)
+	sort.Slice(sortedPosts, func(i, j int) bool { return sortedPosts[i].Date < sortedPosts[j].Date })
+
+	var builder strings.Builder
+	writeICalLine(&builder, "BEGIN:VCALENDAR")
+	writeICalLine(&builder, "VERSION:2.0")
+	writeICalLine(&builder, "PRODID:-//otlozhka-bot//Postponed posts//RU")
+	writeICalLine(&builder, "CALSCALE:GREGORIAN")
+	writeICalLine(&builder, "X-WR-CALNAME:"+escapeICalText(calendarName))
+	stamp := now.UTC().Format(icalTimeLayout)
+	for _, post := range sortedPosts {
+		link := fmt.Sprintf("vk.com/wall%d_%d", post.OwnerID, post.ID)
+		start := time.Unix(int64(post.Date), 0).UTC()
+
+		summary := link
+		var description []string
+		description = append(description, link)
+		if post.SignerID > 0 {
+			author, found := authorNames[post.SignerID]
+			if !found {
+				author = fmt.Sprintf("vk.com/id%d", post.SignerID)
+			}
+			description = append(description, "Автор: "+author)
+		}
+		if postAudios, err := getPostAudios(post); err == nil {
+			var audioTexts []string
+			for _, audio := range postAudios {
+				audioTexts = append(audioTexts, getAudioArtistTitle(audio))
+			}
+			summary = strings.Join(audioTexts, "; ")
+			description = append(description, "🎧: "+summary)
+		}
+		if excerpt := GetTextExcerpt(post.Text, icalExcerptLength); excerpt != "" {
+			description = append(description, excerpt)
+		}
+
+		writeICalLine(&builder, "BEGIN:VEVENT")
+		writeICalLine(&builder, fmt.Sprintf("UID:wall%d_%d@vk.com", post.OwnerID, post.ID))
+		writeICalLine(&builder, "DTSTAMP:"+stamp)
+		writeICalLine(&builder, "DTSTART:"+start.Format(icalTimeLayout))
+		writeICalLine(&builder, "DTEND:"+start.Add(eventDuration).Format(icalTimeLayout))
+		writeICalLine(&builder, "SUMMARY:"+escapeICalText(summary))
+		writeICalLine(&builder, "DESCRIPTION:"+escapeICalText(strings.Join(description, "\n")))
+		writeICalLine(&builder, "URL:https://"+link)
+		writeICalLine(&builder, "END:VEVENT")
+	}
+	writeICalLine(&builder, "END:VCALENDAR")
+	return []byte(builder.String())
+}
+
+// GetTextExcerpt returns the beginning of a text, cut to `length` characters.
+func GetTextExcerpt(text string, length int) string {
+	text = strings.TrimSpace(text)
+	if utf8.RuneCountInString(text) > length {
+		return string([]rune(text)[:length-1]) + "…"
+	}
+	return text
+}
+
+// escapeICalText escapes a TEXT property value (RFC 5545, section 3.3.11).
+func escapeICalText(text string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(text)
+}
+
+// writeICalLine writes a content line, folding it into lines of at most 75 octets without splitting
+// multi-byte characters (RFC 5545, section 3.1). Lines end with CRLF.
+func writeICalLine(builder *strings.Builder, line string) {
+	limit := icalLineLimit
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		builder.WriteString(line[:cut])
+		builder.WriteString("\r\n ")
+		line = line[cut:]
+		// Continuation lines start with a space, which counts towards their length
+		limit = icalLineLimit - 1
+	}
+	builder.WriteString(line)
+	builder.WriteString("\r\n")
+}
@@ -0,0 +1,61 @@
+package api_utils
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestEscapeICalText(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"plain text", "plain text"},
+		{`C:\posts`, `C:\\posts`},
+		{"one; two, three", `one\; two\, three`},
+		{"first\nsecond", `first\nsecond`},
+		{"first\r\nsecond", `first\nsecond`},
+		{"\\;,\n", `\\\;\,\n`},
+	}
+	for _, test := range tests {
+		if got := escapeICalText(test.text); got != test.want {
+			t.Errorf("escapeICalText(%q) = %q, want %q", test.text, got, test.want)
+		}
+	}
+}
+
+func TestWriteICalLine(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want string
+	}{
+		{"short", "SUMMARY:Post", "SUMMARY:Post\r\n"},
+		{"at limit", strings.Repeat("a", 75), strings.Repeat("a", 75) + "\r\n"},
+		{"over limit", strings.Repeat("a", 76), strings.Repeat("a", 75) + "\r\n a\r\n"},
+		{"continuation limit", strings.Repeat("a", 150),
+			strings.Repeat("a", 75) + "\r\n " + strings.Repeat("a", 74) + "\r\n a\r\n"},
+		// 38 two-byte characters: the 75th octet is in the middle of the last one
+		{"multibyte at limit", strings.Repeat("я", 38), strings.Repeat("я", 37) + "\r\n я\r\n"},
+		{"emoji", "X" + strings.Repeat("😀", 20), "X" + strings.Repeat("😀", 18) + "\r\n " + "😀😀\r\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var builder strings.Builder
+			writeICalLine(&builder, test.line)
+			got := builder.String()
+			if got != test.want {
+				t.Errorf("writeICalLine(%q) = %q, want %q", test.line, got, test.want)
+			}
+			for _, line := range strings.Split(strings.TrimSuffix(got, "\r\n"), "\r\n") {
+				if len(line) > 75 || !utf8.ValidString(line) {
+					t.Errorf("folded line %q is not valid UTF-8 of at most 75 octets", line)
+				}
+			}
+			if unfolded := strings.ReplaceAll(strings.TrimSuffix(got, "\r\n"), "\r\n ", ""); unfolded != test.line {
+				t.Errorf("unfolded line %q, want %q", unfolded, test.line)
+			}
+		})
+	}
+}
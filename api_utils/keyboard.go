@@ -0,0 +1,37 @@
+package api_utils
+
+import (
+	"github.com/SevereCloud/vksdk/v2/object"
+)
+
+// maxKeyboardRowButtons is the number of buttons put in a single keyboard row.
+const maxKeyboardRowButtons = 3
+
+// KeyboardButton describes a keyboard button: its label and the payload VK sends back when it's pressed.
+type KeyboardButton struct {
+	Label   string
+	Payload interface{}
+}
+
+// CreateInlineKeyboard creates an inline keyboard with given rows of buttons, placing up to three buttons in a row;
+// longer rows are wrapped. Callback buttons are used if `callback` is true, text buttons otherwise,
+// since not every VK client supports callback buttons. Returns nil if there are no buttons.
+func CreateInlineKeyboard(rows [][]KeyboardButton, callback bool) *object.MessagesKeyboard {
+	keyboard := object.NewMessagesKeyboardInline()
+	for _, row := range rows {
+		for i, button := range row {
+			if i%maxKeyboardRowButtons == 0 {
+				keyboard.AddRow()
+			}
+			if callback {
+				keyboard.AddCallbackButton(button.Label, button.Payload, object.Primary)
+			} else {
+				keyboard.AddTextButton(button.Label, button.Payload, object.Primary)
+			}
+		}
+	}
+	if len(keyboard.Buttons) == 0 {
+		return nil
+	}
+	return keyboard
+}
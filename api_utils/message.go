@@ -6,18 +6,19 @@ import (
 	"sort"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/SevereCloud/vksdk/v2/api"
 	"github.com/SevereCloud/vksdk/v2/api/params"
 	"github.com/SevereCloud/vksdk/v2/object"
 	"github.com/alphatoasterous/otlozhka-bot/config"
-	"github.com/alphatoasterous/otlozhka-bot/logging"
 	"github.com/alphatoasterous/otlozhka-bot/utils"
 )
 
 const RandomId = 0
 
-var messageBuilderConfig = config.BotConfig.MessageBuilder
+// VKMessageLimit is the maximum number of characters in a single VK message.
+const VKMessageLimit = 4096
 
 // extractFormattedAttachmentsFromWallpost extracts and formats attachments from a WallWallpostAttachment.
 // It compiles a string of attachment identifiers for photos, videos, audio, and documents.
@@ -41,13 +42,13 @@ func extractFormattedAttachmentsFromWallpost(attachment object.WallWallpostAttac
 }
 
 // getReadableDate formats a UNIX timestamp into a readable date and time based on a specified timezone.
-// If an error occurs while loading the timezone, it logs the error and exits fatally.
+// Timezone is validated on configuration load; if it still fails to load, the date is formatted in UTC.
 // Returns the formatted time as a string.
-func getReadableDate(timestamp int64) string {
+func getReadableDate(timestamp int64, messageBuilderConfig config.MessageBuilderConfiguration) string {
 	t := time.Unix(timestamp, 0)
 	loc, err := time.LoadLocation(messageBuilderConfig.Timezone)
 	if err != nil {
-		logging.Log.Fatal().Err(err).Msg("Error loading timezone")
+		loc = time.UTC
 	}
 	t = t.In(loc)
 	formattedTime := t.Format(messageBuilderConfig.TimeFormat)
@@ -56,8 +57,9 @@ func getReadableDate(timestamp int64) string {
 
 // getMessageText constructs the message text for a given post using a configurable format.
 // It integrates the post's publication date and text content, formatting the date using getReadableDate.
-func getMessageText(post object.WallWallpost) string {
-	return fmt.Sprintf(messageBuilderConfig.MessageFormat, getReadableDate(int64(post.Date)), post.Text)
+func getMessageText(post object.WallWallpost, messageBuilderConfig config.MessageBuilderConfiguration) string {
+	return fmt.Sprintf(messageBuilderConfig.MessageFormat, getReadableDate(int64(post.Date), messageBuilderConfig),
+		post.Text)
 }
 
 // getPostAudios extracts audio attachments from a WallWallpost.
@@ -83,50 +85,157 @@ func getAudioArtistTitle(audio object.AudioAudio) string {
 	return fmt.Sprintf("%s - %s", audio.Artist, audio.Title)
 }
 
-// CreateMessageSendBuilderByPost prepares a message builder for sending messages,
-// incorporating text and attachments based on a provided WallWallpost.
-func CreateMessageSendBuilderByPost(post object.WallWallpost) *params.MessagesSendBuilder {
-	msg := params.NewMessagesSendBuilder()
-	msg.Message(getMessageText(post))
-	if len(post.Attachments) > 0 {
-		var collectedAttachments string
-		for _, attachment := range post.Attachments {
-			collectedAttachments += extractFormattedAttachmentsFromWallpost(attachment)
+// getPostAttachments returns attachments of a WallWallpost, joined into an attachment string.
+// The string is empty if the post has no attachments.
+func getPostAttachments(post object.WallWallpost) string {
+	var collectedAttachments string
+	for _, attachment := range post.Attachments {
+		collectedAttachments += extractFormattedAttachmentsFromWallpost(attachment)
+	}
+	return collectedAttachments
+}
+
+// createPostMessageSendBuilders creates message send builders with text content, split with
+// CreateMessageSendBuildersText, and attachments of `post` added to the last message, so they follow the whole text.
+func createPostMessageSendBuilders(text string, post object.WallWallpost) []*params.MessagesSendBuilder {
+	builders := CreateMessageSendBuildersText(text)
+	if attachments := getPostAttachments(post); attachments != "" {
+		if len(builders) == 0 {
+			builders = append(builders, CreateMessageSendBuilderText(""))
 		}
-		msg.Attachment(collectedAttachments)
+		builders[len(builders)-1].Attachment(attachments)
 	}
-	msg.RandomID(RandomId)
-	return msg
+	return builders
+}
+
+// CreateMessageSendBuildersByPost prepares message builders for sending a post,
+// incorporating text and attachments based on a provided WallWallpost. Text is formatted as configured in `builder`.
+// Text longer than VK message limit is split into several messages, which should be sent in order.
+func CreateMessageSendBuildersByPost(builder config.MessageBuilderConfiguration,
+	post object.WallWallpost) []*params.MessagesSendBuilder {
+	return createPostMessageSendBuilders(getMessageText(post, builder), post)
+}
+
+// CreateMessageSendBuildersByRescheduledPost prepares message builders notifying an author of a rescheduled post.
+// The `format` string receives previous and current publication dates, formatted the same way as in post messages.
+// The notification is followed by the post itself, as in CreateMessageSendBuildersByPost.
+func CreateMessageSendBuildersByRescheduledPost(builder config.MessageBuilderConfiguration, format string,
+	previous, current object.WallWallpost) []*params.MessagesSendBuilder {
+	notification := fmt.Sprintf(format, getReadableDate(int64(previous.Date), builder),
+		getReadableDate(int64(current.Date), builder))
+	return createPostMessageSendBuilders(notification+"\n\n"+getMessageText(current, builder), current)
+}
+
+// CreateMessageSendBuildersByDeletedPost prepares message builders notifying an author of a post removed
+// from postponed posts before it got published. The `format` string receives the planned publication date.
+// Attachments are not included, since they may not be available anymore.
+func CreateMessageSendBuildersByDeletedPost(builder config.MessageBuilderConfiguration, format string,
+	post object.WallWallpost) []*params.MessagesSendBuilder {
+	notification := fmt.Sprintf(format, getReadableDate(int64(post.Date), builder))
+	return CreateMessageSendBuildersText(notification + "\n\n" + getMessageText(post, builder))
+}
+
+// CreateMessageSendBuildersByUpcomingPost prepares message builders reminding an author of their post publication.
+// The `format` string receives the publication date. The reminder is followed by the post itself,
+// as in CreateMessageSendBuildersByPost.
+func CreateMessageSendBuildersByUpcomingPost(builder config.MessageBuilderConfiguration, format string,
+	post object.WallWallpost) []*params.MessagesSendBuilder {
+	reminder := fmt.Sprintf(format, getReadableDate(int64(post.Date), builder))
+	return createPostMessageSendBuilders(reminder+"\n\n"+getMessageText(post, builder), post)
 }
 
 // CreateMessageSendBuilderText creates a simple message send builder with text content.
+// Text longer than VK message limit is truncated; use CreateMessageSendBuildersText to send it in full.
 func CreateMessageSendBuilderText(text string) *params.MessagesSendBuilder {
-
-	// TODO: Rework this and entire messaging routine altogether by segmenting text into array of messages if needed
-	VK_MESSAGE_LIMIT := 4096
-	if len(text) > VK_MESSAGE_LIMIT {
-		text = text[:VK_MESSAGE_LIMIT-3] + "..."
-	}
 	msg := params.NewMessagesSendBuilder()
-	msg.Message(text)
+	msg.Message(truncateMessageText(text))
 	msg.RandomID(RandomId)
 	return msg
 }
 
-// GetFormattedCalendar groups wall posts by date and formats them into a readable calendar view.
-// The formatting takes into account the timezone, sorting posts by date.
-// Returns a formatted string representing the post calendar or an error if an issue occurs during formatting.
-func GetFormattedCalendar(posts []object.WallWallpost, timezone string) (string, error) {
+// CreateMessageEditBuilderText creates a message edit builder, replacing text of a message
+// identified by its peer and conversation message ID. Text longer than VK message limit is truncated.
+func CreateMessageEditBuilderText(peerID, conversationMessageID int, text string) *params.MessagesEditBuilder {
+	msg := params.NewMessagesEditBuilder()
+	msg.PeerID(peerID)
+	msg.ConversationMessageID(conversationMessageID)
+	msg.Message(truncateMessageText(text))
+	return msg
+}
+
+// truncateMessageText truncates text longer than VK message limit, without splitting multi-byte characters.
+func truncateMessageText(text string) string {
+	if utf8.RuneCountInString(text) > VKMessageLimit {
+		return string([]rune(text)[:VKMessageLimit-1]) + "…"
+	}
+	return text
+}
+
+// CreateMessageSendBuildersText creates message send builders with text content, splitting text
+// longer than VK message limit into several messages with SplitMessageText. Messages should be sent in order.
+func CreateMessageSendBuildersText(text string) []*params.MessagesSendBuilder {
+	parts := SplitMessageText(text, VKMessageLimit)
+	builders := make([]*params.MessagesSendBuilder, 0, len(parts))
+	for _, part := range parts {
+		builders = append(builders, CreateMessageSendBuilderText(part))
+	}
+	return builders
+}
+
+// SplitMessageText splits text into parts of at most `limit` characters.
+// Text is split on the last paragraph break (e.g. between calendar days) fitting into the limit,
+// falling back to the last line break, then to the last space, and only then cutting in the middle of a word.
+// Text is never split inside a multi-byte character. Blank lines at part edges are trimmed.
+// Blank parts are dropped, since VK rejects empty messages, so blank text yields no parts at all.
+func SplitMessageText(text string, limit int) []string {
+	var parts []string
+	for utf8.RuneCountInString(text) > limit {
+		// Byte offset of the first character not fitting into the limit
+		cutIndex := len(text)
+		runeCount := 0
+		for i := range text {
+			if runeCount == limit {
+				cutIndex = i
+				break
+			}
+			runeCount++
+		}
+		head := text[:cutIndex]
+
+		splitIndex := -1
+		for _, separator := range []string{"\n\n", "\n", " "} {
+			if index := strings.LastIndex(head, separator); index > 0 {
+				splitIndex = index
+				break
+			}
+		}
+		if splitIndex == -1 {
+			splitIndex = cutIndex
+		}
+
+		if part := strings.TrimRight(text[:splitIndex], "\n "); strings.TrimSpace(part) != "" {
+			parts = append(parts, part)
+		}
+		text = strings.TrimLeft(text[splitIndex:], "\n ")
+	}
+	if strings.TrimSpace(text) != "" {
+		parts = append(parts, text)
+	}
+	return parts
+}
+
+// loadCalendarLocation loads the timezone calendars are formatted in.
+func loadCalendarLocation(timezone string) (*time.Location, error) {
 	loc, err := time.LoadLocation(timezone)
 	if err != nil {
-		// If loading timezone errors out: default to timezone provided via config. timezone variable may be used in
-		// future, if I could somehow get user's timezone from VK API.
-		loc, err = time.LoadLocation(messageBuilderConfig.Timezone)
-		if err != nil {
-			return "", err // Return an error if the timezone is invalid
-		}
+		return nil, fmt.Errorf("loading calendar timezone: %w", err)
 	}
+	return loc, nil
+}
 
+// groupPostsByDate groups wall posts by their publication date in the given location.
+// Returns dates in ascending order along with posts grouped by them.
+func groupPostsByDate(posts []object.WallWallpost, loc *time.Location) ([]time.Time, map[time.Time][]object.WallWallpost) {
 	// Group posts by date
 	groupedPosts := make(map[time.Time][]object.WallWallpost)
 	for _, post := range posts {
@@ -141,8 +250,25 @@ func GetFormattedCalendar(posts []object.WallWallpost, timezone string) (string,
 		dates = append(dates, date)
 	}
 	sort.Slice(dates, func(i, j int) bool { return dates[i].Before(dates[j]) })
+	return dates, groupedPosts
+}
 
-	// Create the formatted output
+// splitCalendarPages splits dates, in ascending order, into pages of `daysPerPage` calendar days,
+// each one starting at its first date.
+func splitCalendarPages(dates []time.Time, daysPerPage int) [][]time.Time {
+	var pages [][]time.Time
+	for len(dates) > 0 {
+		end := dates[0].AddDate(0, 0, daysPerPage)
+		count := sort.Search(len(dates), func(i int) bool { return !dates[i].Before(end) })
+		pages = append(pages, dates[:count])
+		dates = dates[count:]
+	}
+	return pages
+}
+
+// formatCalendarDays formats posts of given dates into a readable calendar view.
+func formatCalendarDays(dates []time.Time, groupedPosts map[time.Time][]object.WallWallpost,
+	loc *time.Location) (string, error) {
 	var result string
 	for _, date := range dates {
 		dailyPosts := groupedPosts[date]
@@ -168,3 +294,85 @@ func GetFormattedCalendar(posts []object.WallWallpost, timezone string) (string,
 	}
 	return result, nil
 }
+
+// CalendarFilter limits posts included in a calendar. Zero values of its fields don't limit anything.
+type CalendarFilter struct {
+	// From is the earliest publication time included
+	From time.Time
+	// To is the publication time up to which posts are included, exclusive
+	To time.Time
+	// SignerID is the ID of the user whose posts are included
+	SignerID int
+}
+
+// IsEmpty reports whether the filter includes every post.
+func (filter CalendarFilter) IsEmpty() bool {
+	return filter.From.IsZero() && filter.To.IsZero() && filter.SignerID == 0
+}
+
+// Matches reports whether a post is included by the filter.
+func (filter CalendarFilter) Matches(post object.WallWallpost) bool {
+	date := time.Unix(int64(post.Date), 0)
+	if !filter.From.IsZero() && date.Before(filter.From) {
+		return false
+	}
+	if !filter.To.IsZero() && !date.Before(filter.To) {
+		return false
+	}
+	return filter.SignerID == 0 || post.SignerID == filter.SignerID
+}
+
+// filterPosts returns posts included by the filter.
+func filterPosts(posts []object.WallWallpost, filter CalendarFilter) []object.WallWallpost {
+	if filter.IsEmpty() {
+		return posts
+	}
+	var filtered []object.WallWallpost
+	for _, post := range posts {
+		if filter.Matches(post) {
+			filtered = append(filtered, post)
+		}
+	}
+	return filtered
+}
+
+// GetFormattedCalendar groups wall posts by date and formats them into a readable calendar view.
+// The formatting takes into account the timezone, sorting posts by date. Only posts included by the filter are shown.
+// Returns a formatted string representing the post calendar or an error if an issue occurs during formatting.
+// The string is empty if no post is included by the filter.
+func GetFormattedCalendar(posts []object.WallWallpost, timezone string, filter CalendarFilter) (string, error) {
+	loc, err := loadCalendarLocation(timezone)
+	if err != nil {
+		return "", err
+	}
+	dates, groupedPosts := groupPostsByDate(filterPosts(posts, filter), loc)
+	return formatCalendarDays(dates, groupedPosts, loc)
+}
+
+// GetFormattedCalendarPage formats a single page of the calendar view, as GetFormattedCalendar does.
+// Every page covers `daysPerPage` calendar days, starting at the first date with posts not covered
+// by the previous pages, so days without posts never make up a page of their own.
+// `page` is zero-based and gets clamped to existing pages.
+// Returns the formatted page, the number of the page returned, and the total number of pages.
+func GetFormattedCalendarPage(posts []object.WallWallpost, timezone string, filter CalendarFilter,
+	page, daysPerPage int) (string, int, int, error) {
+	loc, err := loadCalendarLocation(timezone)
+	if err != nil {
+		return "", 0, 0, err
+	}
+	dates, groupedPosts := groupPostsByDate(filterPosts(posts, filter), loc)
+
+	pages := splitCalendarPages(dates, daysPerPage)
+	pageCount := max(len(pages), 1)
+	page = min(max(page, 0), pageCount-1)
+	var pageDates []time.Time
+	if page < len(pages) {
+		pageDates = pages[page]
+	}
+
+	result, err := formatCalendarDays(pageDates, groupedPosts, loc)
+	if err != nil {
+		return "", 0, 0, err
+	}
+	return result, page, pageCount, nil
+}
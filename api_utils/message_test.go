@@ -0,0 +1,90 @@
+package api_utils
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/SevereCloud/vksdk/v2/object"
+)
+
+func TestSplitMessageText(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		limit int
+		want  []string
+	}{
+		{"fits", "hello", 10, []string{"hello"}},
+		{"empty", "", 10, nil},
+		{"whitespace only", "\n\n  \n", 10, nil},
+		{"long whitespace only", strings.Repeat(" \n\n", 10), 4, nil},
+		{"paragraph break first", "aaaa\nbb\n\ncc dd", 12, []string{"aaaa\nbb", "cc dd"}},
+		{"line break second", "aaaa bb\ncc dd", 10, []string{"aaaa bb", "cc dd"}},
+		{"space last", "aaaa bbbb cccc", 10, []string{"aaaa bbbb", "cccc"}},
+		{"no separators", "abcdefghij", 4, []string{"abcd", "efgh", "ij"}},
+		{"multibyte at limit", "яяяя", 4, []string{"яяяя"}},
+		{"multibyte over limit", "яяяяя", 4, []string{"яяяя", "я"}},
+		{"emoji", "😀😀😀", 2, []string{"😀😀", "😀"}},
+		{"word at limit", "привет мир", 6, []string{"привет", "мир"}},
+		{"blank part between breaks", "aaaa\n\n\n\n\n\n\n\nbbbb", 5, []string{"aaaa", "bbbb"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parts := SplitMessageText(test.text, test.limit)
+			if !slices.Equal(parts, test.want) {
+				t.Errorf("SplitMessageText(%q, %d) = %q, want %q", test.text, test.limit, parts, test.want)
+			}
+			for _, part := range parts {
+				if !utf8.ValidString(part) || utf8.RuneCountInString(part) > test.limit {
+					t.Errorf("part %q is not valid UTF-8 text of at most %d characters", part, test.limit)
+				}
+			}
+		})
+	}
+}
+
+func TestGetFormattedCalendarPage(t *testing.T) {
+	postOn := func(day int) object.WallWallpost {
+		return object.WallWallpost{OwnerID: -1, ID: day,
+			Date: int(time.Date(2024, time.October, day, 12, 0, 0, 0, time.UTC).Unix())}
+	}
+	posts := []object.WallWallpost{postOn(30), postOn(1), postOn(2), postOn(8), postOn(9), postOn(14)}
+
+	tests := []struct {
+		page      int
+		wantPage  int
+		wantDates []string
+	}{
+		// A week from October 1 ends before October 8
+		{page: 0, wantPage: 0, wantDates: []string{"01.10.2024", "02.10.2024"}},
+		{page: 1, wantPage: 1, wantDates: []string{"08.10.2024", "09.10.2024", "14.10.2024"}},
+		// Days without posts in between don't make up pages
+		{page: 2, wantPage: 2, wantDates: []string{"30.10.2024"}},
+		{page: 5, wantPage: 2, wantDates: []string{"30.10.2024"}},
+		{page: -1, wantPage: 0, wantDates: []string{"01.10.2024", "02.10.2024"}},
+	}
+	for _, test := range tests {
+		calendar, page, pageCount, err := GetFormattedCalendarPage(posts, "UTC", CalendarFilter{}, test.page, 7)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if page != test.wantPage || pageCount != 3 {
+			t.Errorf("page %d: got page %d of %d, want %d of 3", test.page, page, pageCount, test.wantPage)
+		}
+		if dates := calendarDates.FindAllString(calendar, -1); !slices.Equal(dates, test.wantDates) {
+			t.Errorf("page %d: got dates %v, want %v", test.page, dates, test.wantDates)
+		}
+	}
+
+	calendar, page, pageCount, err := GetFormattedCalendarPage(nil, "UTC", CalendarFilter{}, 0, 7)
+	if err != nil || calendar != "" || page != 0 || pageCount != 1 {
+		t.Errorf("empty calendar: got %q, page %d of %d, %v", calendar, page, pageCount, err)
+	}
+}
+
+// calendarDates matches day headers of a formatted calendar.
+var calendarDates = regexp.MustCompile(`\d{2}\.\d{2}\.\d{4}`)
@@ -0,0 +1,133 @@
+package api_utils
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/SevereCloud/vksdk/v2/object"
+	"github.com/alphatoasterous/otlozhka-bot/config"
+)
+
+// ScheduleGap is a period without posts between two consecutive posts, between the start of a check and
+// the first post, or between the last post and the end of a check.
+type ScheduleGap struct {
+	From time.Time
+	To   time.Time
+}
+
+// ScheduleReport lists problems found in a schedule of postponed posts.
+type ScheduleReport struct {
+	// Last day checked
+	Until time.Time
+	// Gaps longer than this are reported
+	MaxGap time.Duration
+	// Groups of posts published within the collision window of each other, in order of publication
+	Collisions [][]object.WallWallpost
+	// Days without a single post
+	EmptyDays []time.Time
+	// Gaps between posts longer than the maximum gap
+	Gaps []ScheduleGap
+}
+
+// IsEmpty reports whether no problems were found.
+func (report ScheduleReport) IsEmpty() bool {
+	return len(report.Collisions) == 0 && len(report.EmptyDays) == 0 && len(report.Gaps) == 0
+}
+
+// AnalyzeSchedule checks posts published from `now` until the end of `days` days, starting today, for collisions,
+// empty days and gaps. Posts published `collisionWindow` apart or closer collide,
+// gaps longer than `maxGap` are reported, including the one from the last post (or `now`, if there are no posts)
+// to the end of the check. Days are taken in the given location.
+func AnalyzeSchedule(posts []object.WallWallpost, now time.Time, loc *time.Location,
+	collisionWindow, maxGap time.Duration, days int) ScheduleReport {
+	now = now.In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	end := today.AddDate(0, 0, days)
+	report := ScheduleReport{Until: end.AddDate(0, 0, -1), MaxGap: maxGap}
+
+	var upcoming []object.WallWallpost
+	for _, post := range posts {
+		date := time.Unix(int64(post.Date), 0)
+		if !date.Before(now) && date.Before(end) {
+			upcoming = append(upcoming, post)
+		}
+	}
+	sort.Slice(upcoming, func(i, j int) bool { return upcoming[i].Date < upcoming[j].Date })
+
+	postDays := make(map[time.Time]bool)
+	previous := now
+	var collision []object.WallWallpost
+	for i, post := range upcoming {
+		date := time.Unix(int64(post.Date), 0).In(loc)
+		postDays[time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, loc)] = true
+
+		if date.Sub(previous) > maxGap {
+			report.Gaps = append(report.Gaps, ScheduleGap{From: previous, To: date})
+		}
+		previous = date
+
+		if i > 0 && date.Sub(time.Unix(int64(upcoming[i-1].Date), 0)) <= collisionWindow {
+			if len(collision) == 0 {
+				collision = append(collision, upcoming[i-1])
+			}
+			collision = append(collision, post)
+		} else if len(collision) > 0 {
+			report.Collisions = append(report.Collisions, collision)
+			collision = nil
+		}
+	}
+	if len(collision) > 0 {
+		report.Collisions = append(report.Collisions, collision)
+	}
+	if end.Sub(previous) > maxGap {
+		report.Gaps = append(report.Gaps, ScheduleGap{From: previous, To: end})
+	}
+
+	for day := today; day.Before(end); day = day.AddDate(0, 0, 1) {
+		if !postDays[day] {
+			report.EmptyDays = append(report.EmptyDays, day)
+		}
+	}
+	return report
+}
+
+// GetFormattedScheduleReport formats a schedule report into a readable view, listing colliding posts
+// the same way GetFormattedCalendar does. Headers are taken from `scheduleConfig`.
+func GetFormattedScheduleReport(report ScheduleReport, loc *time.Location,
+	scheduleConfig config.ScheduleConfiguration) (string, error) {
+	result := fmt.Sprintf(scheduleConfig.HeaderFormat, report.Until.Format("02.01.2006")) + "\n"
+	if report.IsEmpty() {
+		return result + scheduleConfig.NoProblemsMsg, nil
+	}
+
+	if len(report.Collisions) > 0 {
+		result += "\n" + scheduleConfig.CollisionsHeader + "\n"
+		for _, collision := range report.Collisions {
+			dates, groupedPosts := groupPostsByDate(collision, loc)
+			days, err := formatCalendarDays(dates, groupedPosts, loc)
+			if err != nil {
+				return "", err
+			}
+			result += days
+		}
+	}
+
+	if len(report.EmptyDays) > 0 {
+		var days []string
+		for _, day := range report.EmptyDays {
+			days = append(days, day.Format("02.01.2006"))
+		}
+		result += "\n" + scheduleConfig.EmptyDaysHeader + "\n📅 " + strings.Join(days, ", ") + "\n"
+	}
+
+	if len(report.Gaps) > 0 {
+		result += "\n" + fmt.Sprintf(scheduleConfig.GapsHeaderFormat, int(report.MaxGap.Hours())) + "\n"
+		for _, gap := range report.Gaps {
+			result += fmt.Sprintf("%s — %s (%d ч.)\n", gap.From.In(loc).Format("02.01.2006 15:04"),
+				gap.To.In(loc).Format("02.01.2006 15:04"), int(gap.To.Sub(gap.From).Hours()))
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,50 @@
+package api_utils
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/SevereCloud/vksdk/v2/object"
+)
+
+func TestAnalyzeScheduleTailGap(t *testing.T) {
+	loc := time.UTC
+	now := time.Date(2024, time.May, 10, 9, 0, 0, 0, loc)
+	// The check ends at midnight after two days, 39 hours after now
+	end := time.Date(2024, time.May, 12, 0, 0, 0, 0, loc)
+	postAt := func(hours int) object.WallWallpost {
+		return object.WallWallpost{ID: hours, Date: int(now.Add(time.Duration(hours) * time.Hour).Unix())}
+	}
+
+	tests := []struct {
+		name     string
+		posts    []object.WallWallpost
+		wantGaps []ScheduleGap
+	}{
+		{
+			name:     "no posts",
+			wantGaps: []ScheduleGap{{From: now, To: end}},
+		},
+		{
+			name:     "gap after the last post",
+			posts:    []object.WallWallpost{postAt(2), postAt(6)},
+			wantGaps: []ScheduleGap{{From: now.Add(6 * time.Hour), To: end}},
+		},
+		{
+			name:  "last post close to the end",
+			posts: []object.WallWallpost{postAt(8), postAt(16), postAt(24), postAt(32)},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			report := AnalyzeSchedule(test.posts, now, loc, time.Hour, 12*time.Hour, 2)
+			equal := slices.EqualFunc(report.Gaps, test.wantGaps, func(a, b ScheduleGap) bool {
+				return a.From.Equal(b.From) && a.To.Equal(b.To)
+			})
+			if !equal {
+				t.Errorf("gaps %v, want %v", report.Gaps, test.wantGaps)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package api_utils
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/SevereCloud/vksdk/v2/object"
+	"github.com/alphatoasterous/otlozhka-bot/config"
+)
+
+// SlotGrid is a daily publishing grid: a slot every Interval from Start to End, both given as time since midnight.
+type SlotGrid struct {
+	Start    time.Duration
+	End      time.Duration
+	Interval time.Duration
+	// A slot is taken by a post published within TakenWindow of it
+	TakenWindow time.Duration
+}
+
+// GetFreeSlots returns up to `count` earliest grid slots after `now` and within `days` days, starting today,
+// which are not taken by any of the posts. Grid times are taken in the given location.
+func GetFreeSlots(posts []object.WallWallpost, now time.Time, loc *time.Location, grid SlotGrid,
+	count, days int) []time.Time {
+	if grid.Interval <= 0 {
+		return nil
+	}
+	now = now.In(loc)
+	var slots []time.Time
+	for day := 0; day < days && len(slots) < count; day++ {
+		midnight := time.Date(now.Year(), now.Month(), now.Day()+day, 0, 0, 0, 0, loc)
+		for offset := grid.Start; offset <= grid.End && len(slots) < count; offset += grid.Interval {
+			slot := midnight.Add(offset)
+			if slot.After(now) && !isSlotTaken(slot, posts, grid.TakenWindow) {
+				slots = append(slots, slot)
+			}
+		}
+	}
+	return slots
+}
+
+// isSlotTaken reports whether any post is published within `window` of the slot.
+func isSlotTaken(slot time.Time, posts []object.WallWallpost, window time.Duration) bool {
+	for _, post := range posts {
+		distance := time.Unix(int64(post.Date), 0).Sub(slot)
+		if distance <= window && distance >= -window {
+			return true
+		}
+	}
+	return false
+}
+
+// GetFormattedFreeSlots formats free slots into a readable view, grouping them by date
+// the same way GetFormattedCalendar does. Header and the message for no slots are taken from `slotsConfig`.
+func GetFormattedFreeSlots(slots []time.Time, loc *time.Location, slotsConfig config.SlotsConfiguration) string {
+	if len(slots) == 0 {
+		return slotsConfig.NoSlotsMsg
+	}
+	result := slotsConfig.Header + "\n"
+	var date string
+	var times []string
+	for _, slot := range slots {
+		slot = slot.In(loc)
+		if slotDate := slot.Format("02.01.2006"); slotDate != date {
+			if len(times) > 0 {
+				result += fmt.Sprintf("📅 %s: %s\n", date, strings.Join(times, ", "))
+			}
+			date, times = slotDate, nil
+		}
+		times = append(times, slot.Format("15:04"))
+	}
+	result += fmt.Sprintf("📅 %s: %s", date, strings.Join(times, ", "))
+	return result
+}
@@ -0,0 +1,32 @@
+package api_utils
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/SevereCloud/vksdk/v2/api"
+)
+
+// usersGetLimit is the maximum number of user IDs accepted by a single users.get call.
+const usersGetLimit = 1000
+
+// GetUserNames retrieves full names of users with given IDs, mapped by user ID.
+// Users VK doesn't return, e.g. deleted ones, are missing from the result.
+func GetUserNames(vk *api.VK, userIDs []int) (map[int]string, error) {
+	names := make(map[int]string, len(userIDs))
+	for start := 0; start < len(userIDs); start += usersGetLimit {
+		batch := userIDs[start:min(start+usersGetLimit, len(userIDs))]
+		ids := make([]string, 0, len(batch))
+		for _, userID := range batch {
+			ids = append(ids, fmt.Sprint(userID))
+		}
+		users, err := vk.UsersGet(api.Params{"user_ids": strings.Join(ids, ",")})
+		if err != nil {
+			return nil, fmt.Errorf("getting user names: %w", err)
+		}
+		for _, user := range users {
+			names[user.ID] = strings.TrimSpace(user.FirstName + " " + user.LastName)
+		}
+	}
+	return names, nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/SevereCloud/vksdk/v2/callback"
+	"github.com/SevereCloud/vksdk/v2/events"
+	"github.com/alphatoasterous/otlozhka-bot/config"
+	"github.com/rs/zerolog"
+)
+
+// httpShutdownTimeout limits the time given to HTTP servers to finish active requests on shutdown.
+const httpShutdownTimeout = time.Second * 10
+
+// runCallbackServer serves VK Callback API on the configured address and path, passing events to `eventHandlers`.
+// The confirmation handshake and secret key verification are handled by the callback package.
+// The server refuses to start without a secret key, since unverified events could be forged by anyone.
+// It blocks until ctx is cancelled, then shuts the server down gracefully.
+func runCallbackServer(ctx context.Context, callbackConfig config.CallbackConfiguration,
+	eventHandlers *events.FuncList, logger zerolog.Logger) error {
+	if callbackConfig.SecretKey == "" {
+		return errors.New("callback API secret key is not set, refusing to accept unverified events")
+	}
+
+	cb := callback.NewCallback()
+	cb.ConfirmationKey = callbackConfig.ConfirmationKey
+	cb.SecretKey = callbackConfig.SecretKey
+	cb.ErrorLog = log.New(logger, "", 0)
+	cb.FuncList = *eventHandlers
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(callbackConfig.Path, cb.HandleFunc)
+	server := &http.Server{
+		Addr:              callbackConfig.Address,
+		Handler:           mux,
+		ReadHeaderTimeout: time.Second * 10,
+	}
+
+	logger.Info().Str("address", callbackConfig.Address).Str("path", callbackConfig.Path).
+		Msg("Callback API server listening")
+	return serveHTTP(ctx, server)
+}
+
+// serveHTTP runs an HTTP server until ctx is cancelled, then shuts it down gracefully,
+// giving active requests up to httpShutdownTimeout to finish.
+func serveHTTP(ctx context.Context, server *http.Server) error {
+	shutdownErr := make(chan error, 1)
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+		defer cancel()
+		shutdownErr <- server.Shutdown(shutdownCtx)
+	}()
+
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return <-shutdownErr
+}
@@ -0,0 +1,19 @@
+package config
+
+// Command names, used in chat rules, keyboard labels and help descriptions to refer to bot commands.
+const (
+	CommandOtlozhka      = "otlozhka"
+	CommandUpdateStorage = "update"
+	CommandPrintStorage  = "calendar"
+	CommandHelp          = "help"
+	CommandSchedule      = "schedule"
+	CommandFreeSlots     = "slots"
+	CommandICalendar     = "ics"
+	CommandExport        = "export"
+)
+
+// CommandNames lists names of every bot command.
+var CommandNames = []string{
+	CommandOtlozhka, CommandFreeSlots, CommandUpdateStorage, CommandPrintStorage, CommandSchedule,
+	CommandICalendar, CommandExport, CommandHelp,
+}
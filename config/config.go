@@ -1,21 +1,34 @@
 package config
 
 import (
-	"flag"
+	"bytes"
+	"errors"
 	"fmt"
 	"os"
+	"reflect"
 	"regexp"
+	"sync/atomic"
 
 	"github.com/pelletier/go-toml/v2"
 )
 
 type (
 	BotConfiguration struct {
-		Main            mainConfig
+		Main            MainConfiguration
 		ZerologConfig   ZerologConfiguration
-		MessageBuilder  messageBuilderConfig
-		MessageHandler  messageHandlerConfig
-		CompiledRegexes compiledRegexes
+		MessageBuilder  MessageBuilderConfiguration
+		MessageHandler  MessageHandlerConfiguration
+		Help            HelpConfiguration
+		Keyboard        KeyboardConfiguration
+		Callback        CallbackConfiguration
+		Chats           []ChatConfiguration
+		Notifications   NotificationsConfiguration
+		Reminders       RemindersConfiguration
+		Schedule        ScheduleConfiguration
+		Slots           SlotsConfiguration
+		ICalendar       ICalendarConfiguration
+		Export          ExportConfiguration
+		CompiledRegexes CompiledRegexes
 	}
 
 	ZerologConfiguration struct {
@@ -39,49 +52,204 @@ type (
 		MaxAge int
 	}
 
-	mainConfig struct {
+	MainConfiguration struct {
 		UserToken             string
 		CommunityToken        string
 		CommunityAPIRateLimit int
 		UserAPIRateLimit      int
 		StorageKeepAlive      int
+		StorageSnapshotPath   string
+
+		StorageRefreshInterval   int
+		StorageRefreshJitter     int
+		StorageRefreshMaxBackoff int
+
+		EventsMode      string
+		ShutdownTimeout int
 	}
 
-	messageBuilderConfig struct {
+	MessageBuilderConfiguration struct {
 		MessageFormat string
 		TimeFormat    string
 		Timezone      string
+
+		// Number of calendar days a single calendar page covers; 0 sends the whole calendar at once
+		CalendarDaysPerPage int
+		CalendarPageFormat  string
 	}
 
-	messageHandlerConfig struct {
+	MessageHandlerConfiguration struct {
 		OtlozhkaRegex      string
 		UpdateStorageRegex string
 		PrintStorageRegex  string
+		HelpRegex          string
+		ScheduleRegex      string
+		FreeSlotsRegex     string
+		ICalendarRegex     string
+		ExportRegex        string
 
 		StorageUpdatedMsgs        []string
 		StorageUpdatedCommendMsgs []string
 		StorageEmptyMsgs          []string
 
+		CalendarNothingFoundMsgs  []string
+		CalendarFilterInvalidMsgs []string
+
 		PostponedPostsFoundMsgs   []string
 		NoPostponedPostsFoundMsgs []string
+
+		ErrorMsgs []string
+	}
+
+	HelpConfiguration struct {
+		// Header is sent before the list of commands
+		Header string
+		// CommandFormat formats every command with its description and example phrasing
+		CommandFormat string
+		// Descriptions maps command names to their descriptions
+		Descriptions map[string]string
+	}
+
+	KeyboardConfiguration struct {
+		Enabled bool
+		// Labels maps command names to labels of their buttons; commands without a label get no button
+		Labels map[string]string
+
+		PreviousPageLabel string
+		NextPageLabel     string
+	}
+
+	CallbackConfiguration struct {
+		Address         string
+		Path            string
+		ConfirmationKey string
+		SecretKey       string
+	}
+
+	// ChatConfiguration lists what the bot may do in a specific group chat.
+	ChatConfiguration struct {
+		// Chat peer ID, 2000000000 + chat number
+		PeerID int
+		// Names of commands allowed in the chat
+		AllowedCommands []string
+		// Staff chat members may run manager commands, even if they don't manage the community
+		Staff bool
+		// Send "otlozhka" answers privately to the sender instead of the chat
+		ReplyPrivately bool
+	}
+
+	NotificationsConfiguration struct {
+		Enabled              bool
+		RescheduledMsgFormat string
+		DeletedMsgFormat     string
 	}
 
-	compiledRegexes struct {
+	RemindersConfiguration struct {
+		Enabled           bool
+		LeadTimes         []int
+		CheckInterval     int
+		ReminderMsgFormat string
+		SentRemindersPath string
+	}
+
+	ScheduleConfiguration struct {
+		// Posts published this many minutes apart or closer collide
+		CollisionWindow int
+		// Gaps between posts longer than this many hours are reported
+		MaxGap int
+		// Number of days, starting today, checked for collisions, empty days and gaps
+		LookaheadDays int
+
+		HeaderFormat     string
+		CollisionsHeader string
+		EmptyDaysHeader  string
+		GapsHeaderFormat string
+		NoProblemsMsg    string
+
+		// Daily report is sent to ReportPeerIDs at ReportTime (HH:MM in MessageBuilder.Timezone),
+		// unless no problems are found
+		ReportEnabled bool
+		ReportTime    string
+		ReportPeerIDs []int
+	}
+
+	// SlotsConfiguration describes free publication slots suggested to authors.
+	// A slot is taken by a post published within Schedule.CollisionWindow of it.
+	SlotsConfiguration struct {
+		// Publishing grid: a slot every GridInterval minutes from GridStart to GridEnd (HH:MM in MessageBuilder.Timezone)
+		GridStart    string
+		GridEnd      string
+		GridInterval int
+
+		SuggestCount  int
+		LookaheadDays int
+
+		Header     string
+		NoSlotsMsg string
+		// Suggest free slots to authors whose "otlozhka" request found no posts
+		SuggestWhenNothingFound bool
+	}
+
+	ICalendarConfiguration struct {
+		// Serve the iCalendar file over HTTP on Address and Path
+		ServerEnabled bool
+		Address       string
+		Path          string
+		// If set, requests must pass it as the "token" query parameter
+		Token string
+
+		CalendarName string
+		// Duration of calendar events, in minutes
+		EventDuration int
+		// Name of the document sent by the export command
+		Filename    string
+		DocumentMsg string
+	}
+
+	ExportConfiguration struct {
+		// Format used if the export request names none: "csv" or "json"
+		DefaultFormat string
+		// Name of the exported document, without extension
+		Filename    string
+		DocumentMsg string
+	}
+
+	// CompiledRegexes holds regular expressions of MessageHandler, compiled by Validate.
+	CompiledRegexes struct {
 		Otlozhka      *regexp.Regexp
 		UpdateStorage *regexp.Regexp
 		PrintStorage  *regexp.Regexp
+		Help          *regexp.Regexp
+		Schedule      *regexp.Regexp
+		FreeSlots     *regexp.Regexp
+		ICalendar     *regexp.Regexp
+		Export        *regexp.Regexp
 	}
 )
 
+// Ways of receiving VK events, selected with the EventsMode setting.
+const (
+	EventsModeLongPoll = "longpoll"
+	EventsModeCallback = "callback"
+)
+
 func DefaultBotConfiguration() BotConfiguration {
 	return BotConfiguration{
 
-		Main: mainConfig{
+		Main: MainConfiguration{
 			UserToken:             "",
 			CommunityToken:        "",
 			CommunityAPIRateLimit: 5,
 			UserAPIRateLimit:      1,
 			StorageKeepAlive:      900,
+			StorageSnapshotPath:   "wallposts.json",
+
+			StorageRefreshInterval:   600,
+			StorageRefreshJitter:     60,
+			StorageRefreshMaxBackoff: 600,
+
+			EventsMode:      EventsModeLongPoll,
+			ShutdownTimeout: 30,
 		},
 		ZerologConfig: ZerologConfiguration{
 			ConsoleLoggingEnabled: true,
@@ -93,67 +261,188 @@ func DefaultBotConfiguration() BotConfiguration {
 			MaxBackups:            5,
 			MaxAge:                30,
 		},
-		MessageBuilder: messageBuilderConfig{
+		MessageBuilder: MessageBuilderConfiguration{
 			MessageFormat: "📅 : %s\n📝: %s",
 			TimeFormat:    "02.01.2006 15:04:05",
 			Timezone:      "Europe/Moscow",
+
+			CalendarDaysPerPage: 7,
+			CalendarPageFormat:  "Страница %d из %d",
 		},
-		MessageHandler: messageHandlerConfig{
+		MessageHandler: MessageHandlerConfiguration{
 			OtlozhkaRegex:             "отложк[ауе]",
 			UpdateStorageRegex:        "обнови",
 			PrintStorageRegex:         "календарь",
+			HelpRegex:                 "помощь|команды",
+			ScheduleRegex:             "проверь расписание|дыры в расписании",
+			FreeSlotsRegex:            "свободн(ые|ое) (слоты|время)",
+			ICalendarRegex:            `\bics\b|экспорт календаря`,
+			ExportRegex:               `выгрузк[аиу]|\b(csv|json)\b`,
 			StorageUpdatedMsgs:        []string{"Хранилище синхронизировано. Следующее обновление через 15 минут."},
 			StorageUpdatedCommendMsgs: []string{"Хранилище синхронизировано. Спасибо за Ваш труд!"},
 			StorageEmptyMsgs:          []string{"В хранилище пусто. Вероятно, в сообществе нет отложенных постов."},
+			CalendarNothingFoundMsgs:  []string{"Подходящих отложенных постов не найдено."},
+			CalendarFilterInvalidMsgs: []string{"Не удалось разобрать дату. Пример: «календарь 20.10–27.10» или «календарь завтра»."},
 			PostponedPostsFoundMsgs:   []string{""},
 			NoPostponedPostsFoundMsgs: []string{"Отложенных постов не найдено."},
+			ErrorMsgs:                 []string{"Что-то пошло не так. Попробуйте ещё раз позже."},
+		},
+		Help: HelpConfiguration{
+			Header:        "Доступные команды:",
+			CommandFormat: "• %s\n  Например: «%s»",
+			Descriptions: map[string]string{
+				"otlozhka": "показать Ваши посты в отложке",
+				"update":   "обновить хранилище отложенных постов",
+				"calendar": "календарь отложенных постов",
+				"help":     "список доступных команд",
+				"schedule": "найти посты, опубликованные почти одновременно, дни без постов и долгие перерывы",
+				"slots":    "ближайшее свободное время для публикации",
+				"ics":      "календарь отложенных постов файлом для Google или Apple Календаря",
+				"export":   "выгрузка отложенных постов в CSV или JSON для отчётов",
+			},
+		},
+		Keyboard: KeyboardConfiguration{
+			Enabled: true,
+			Labels: map[string]string{
+				"otlozhka": "Мои посты",
+				"slots":    "Свободное время",
+				"calendar": "Календарь",
+				"update":   "Обновить",
+			},
+			PreviousPageLabel: "◀ Назад",
+			NextPageLabel:     "Вперёд ▶",
+		},
+		Callback: CallbackConfiguration{
+			Address:         ":8080",
+			Path:            "/callback",
+			ConfirmationKey: "",
+			SecretKey:       "",
+		},
+		Chats: []ChatConfiguration{},
+		Notifications: NotificationsConfiguration{
+			Enabled:              true,
+			RescheduledMsgFormat: "Время публикации Вашего поста изменено: %s ➡ %s",
+			DeletedMsgFormat:     "Ваш пост, запланированный на %s, убран из отложенных записей.",
 		},
+		Reminders: RemindersConfiguration{
+			Enabled:           true,
+			LeadTimes:         []int{1440, 60},
+			CheckInterval:     60,
+			ReminderMsgFormat: "Напоминание: Ваш пост будет опубликован %s.",
+			SentRemindersPath: "reminders.json",
+		},
+		Schedule: ScheduleConfiguration{
+			CollisionWindow:  5,
+			MaxGap:           12,
+			LookaheadDays:    14,
+			HeaderFormat:     "Проверка расписания до %s:",
+			CollisionsHeader: "⚠ Посты почти одновременно:",
+			EmptyDaysHeader:  "🕳 Дни без постов:",
+			GapsHeaderFormat: "⏳ Перерывы дольше %d ч.:",
+			NoProblemsMsg:    "Проблем в расписании не найдено.",
+			ReportEnabled:    false,
+			ReportTime:       "10:00",
+			ReportPeerIDs:    []int{},
+		},
+		Slots: SlotsConfiguration{
+			GridStart:               "09:00",
+			GridEnd:                 "23:00",
+			GridInterval:            120,
+			SuggestCount:            5,
+			LookaheadDays:           14,
+			Header:                  "Свободное время для публикации:",
+			NoSlotsMsg:              "Свободного времени для публикации в ближайшие дни нет.",
+			SuggestWhenNothingFound: true,
+		},
+		ICalendar: ICalendarConfiguration{
+			ServerEnabled: false,
+			Address:       "127.0.0.1:8081",
+			Path:          "/calendar.ics",
+			Token:         "",
+			CalendarName:  "Отложенные посты",
+			EventDuration: 15,
+			Filename:      "otlozhka.ics",
+			DocumentMsg:   "Календарь отложенных постов. Откройте файл или импортируйте его в Google или Apple Календарь.",
+		},
+		Export: ExportConfiguration{
+			DefaultFormat: "csv",
+			Filename:      "otlozhka",
+			DocumentMsg:   "Выгрузка отложенных постов.",
+		},
+	}
+}
+
+// Load reads the configuration file at `path` over default parameters, applies environment variables,
+// which take precedence over the file, and validates the result.
+// Keys of the file unknown to BotConfiguration, such as misspelled ones, are reported as validation problems.
+func Load(path string) (*BotConfiguration, error) {
+	loaded := DefaultBotConfiguration()
+	tomlFile, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", path, err)
 	}
+	var unknownKeys []error
+	if err := toml.NewDecoder(bytes.NewReader(tomlFile)).DisallowUnknownFields().Decode(&loaded); err != nil {
+		var strictErr *toml.StrictMissingError
+		if !errors.As(err, &strictErr) {
+			return nil, fmt.Errorf("error unmarshalling %s: %w", path, err)
+		}
+		// Known keys are decoded all the same, so unknown ones are reported along with other problems
+		unknownKeys = unknownKeyErrors(strictErr)
+	}
+	if err := applyEnvOverrides(&loaded); err != nil {
+		return nil, fmt.Errorf("error applying environment variables: %w", err)
+	}
+	if err := errors.Join(append(unknownKeys, loaded.Validate())...); err != nil {
+		return nil, fmt.Errorf("invalid configuration in %s:\n%w", path, err)
+	}
+	return &loaded, nil
 }
 
-var BotConfig BotConfiguration
+// Store holds the configuration in effect, loaded from a file, and reloads it on demand.
+// It is safe for concurrent use.
+type Store struct {
+	path    string
+	current atomic.Pointer[BotConfiguration]
+}
 
-func init() {
-	configFilename := flag.String("config", "config.toml", "Specify config filename")
+// NewStore creates a Store holding `cfg`, loaded from the configuration file at `path`.
+func NewStore(path string, cfg *BotConfiguration) *Store {
+	store := &Store{path: path}
+	store.current.Store(cfg)
+	return store
+}
 
-	BotConfig = DefaultBotConfiguration()
+// Current returns the configuration in effect. The returned configuration must not be modified;
+// callers needing several settings consistent with each other should call Current once and keep the result.
+func (store *Store) Current() *BotConfiguration {
+	return store.current.Load()
+}
 
-	fmt.Printf("DEBUG: Loading configuration from a file")
-	// Check if config.toml exists
-	_, err := os.Stat(*configFilename)
-	if os.IsNotExist(err) {
-		fmt.Printf("WARNING: %s does not exist, creating it with default parameters\n", *configFilename)
-		tomlBotConfig, err := toml.Marshal(BotConfig)
-		if err != nil {
-			fmt.Printf("ERROR: Cannot marshal BotConfig: %v, path: %s\n", err, *configFilename)
-			return
-		}
-		err = os.WriteFile(*configFilename, tomlBotConfig, 0644)
-		if err != nil {
-			fmt.Printf("ERROR: Error writing marshalled BotConfig to a %s", *configFilename)
-			return
-		}
-	} else {
-		fmt.Printf("DEBUG: %s does exist, unmarshalling it\n", *configFilename)
-		tomlFile, err := os.ReadFile(*configFilename)
-		if err != nil {
-			fmt.Printf("ERROR: Error reading %s: %v\n", *configFilename, err)
-			return
-		}
-		err = toml.Unmarshal(tomlFile, &BotConfig)
-		if err != nil {
-			fmt.Printf("ERROR: Error unmarshalling %s: %v\n", *configFilename, err)
-			return
-		}
+// Reload reads the configuration file again, applies environment variables and validates the result.
+// If the new configuration is valid, it atomically replaces the current one. Otherwise the current
+// configuration stays in effect. Returns the configuration replaced along with the new one.
+func (store *Store) Reload() (previous *BotConfiguration, reloaded *BotConfiguration, err error) {
+	reloaded, err = Load(store.path)
+	if err != nil {
+		return nil, nil, err
 	}
+	return store.current.Swap(reloaded), reloaded, nil
+}
 
-	if BotConfig.Main.UserToken == "" || BotConfig.Main.CommunityToken == "" {
-		fmt.Println("ERROR: No UserToken or CommunityToken provided")
-		return
+// ChangedSections lists names of configuration sections which differ between two configurations.
+func ChangedSections(previous, reloaded *BotConfiguration) []string {
+	var changed []string
+	previousValue, reloadedValue := reflect.ValueOf(previous).Elem(), reflect.ValueOf(reloaded).Elem()
+	for i := 0; i < previousValue.NumField(); i++ {
+		name := previousValue.Type().Field(i).Name
+		// Compiled regular expressions change along with MessageHandler
+		if name == "CompiledRegexes" {
+			continue
+		}
+		if !reflect.DeepEqual(previousValue.Field(i).Interface(), reloadedValue.Field(i).Interface()) {
+			changed = append(changed, name)
+		}
 	}
-
-	BotConfig.CompiledRegexes.Otlozhka = regexp.MustCompile(BotConfig.MessageHandler.OtlozhkaRegex)
-	BotConfig.CompiledRegexes.UpdateStorage = regexp.MustCompile(BotConfig.MessageHandler.UpdateStorageRegex)
-	BotConfig.CompiledRegexes.PrintStorage = regexp.MustCompile(BotConfig.MessageHandler.PrintStorageRegex)
-
+	return changed
 }
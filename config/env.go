@@ -0,0 +1,90 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+// EnvPrefix starts names of environment variables overriding configuration fields.
+const EnvPrefix = "OTLOZHKA"
+
+// envFileSuffix ends names of environment variables holding paths to files with field values, e.g. secrets.
+const envFileSuffix = "_FILE"
+
+// applyEnvOverrides overrides configuration fields with environment variables.
+// A field is named by its section and its own name in upper case, e.g. Main.UserToken is OTLOZHKA_MAIN_USERTOKEN;
+// sections which aren't tables, such as Chats, are named by the section alone (OTLOZHKA_CHATS).
+// A variable with the _FILE suffix (OTLOZHKA_MAIN_USERTOKEN_FILE) names a file holding the value instead;
+// trailing line breaks are trimmed from it. If both are set, the variable holding the value itself wins.
+// String values are taken as is, other values are parsed as TOML, e.g. `true`, `[1440, 60]`
+// or `{ otlozhka = 'Мои посты' }`.
+func applyEnvOverrides(config *BotConfiguration) error {
+	return applyEnvOverridesToStruct(reflect.ValueOf(config).Elem(), EnvPrefix)
+}
+
+// applyEnvOverridesToStruct overrides fields of a struct, named with the given prefix.
+func applyEnvOverridesToStruct(value reflect.Value, prefix string) error {
+	valueType := value.Type()
+	for i := 0; i < valueType.NumField(); i++ {
+		field := valueType.Field(i)
+		// Compiled regular expressions are derived from other fields
+		if !field.IsExported() || field.Name == "CompiledRegexes" {
+			continue
+		}
+		name := prefix + "_" + strings.ToUpper(field.Name)
+		if field.Type.Kind() == reflect.Struct {
+			if err := applyEnvOverridesToStruct(value.Field(i), name); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := applyEnvOverride(value.Field(i), name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyEnvOverride sets a field from the environment variable `name`, or from the file named by `name`_FILE.
+// The field is left unchanged if neither is set.
+func applyEnvOverride(field reflect.Value, name string) error {
+	envValue, found := os.LookupEnv(name)
+	if !found {
+		path, found := os.LookupEnv(name + envFileSuffix)
+		if !found {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("reading %s%s: %w", name, envFileSuffix, err)
+		}
+		envValue = strings.TrimRight(string(data), "\r\n")
+	}
+
+	if field.Kind() == reflect.String {
+		field.SetString(envValue)
+		return nil
+	}
+	// Parsing the value as TOML, the same way a value in config.toml is parsed
+	wrapperType := reflect.StructOf([]reflect.StructField{{
+		Name: "Value",
+		Type: field.Type(),
+		Tag:  `toml:"value"`,
+	}})
+	wrapper := reflect.New(wrapperType)
+	decoder := toml.NewDecoder(strings.NewReader("value = " + envValue)).DisallowUnknownFields()
+	if err := decoder.Decode(wrapper.Interface()); err != nil {
+		var strictErr *toml.StrictMissingError
+		if errors.As(err, &strictErr) {
+			return fmt.Errorf("parsing %s: %w", name, errors.Join(unknownKeyErrors(strictErr)...))
+		}
+		return fmt.Errorf("parsing %s: %w", name, err)
+	}
+	field.Set(wrapper.Elem().Field(0))
+	return nil
+}
@@ -0,0 +1,184 @@
+package config
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// writeTestConfig writes a configuration file with given contents to a temporary directory and returns its path.
+// Tokens are always set, since the configuration is invalid without them.
+func writeTestConfig(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.toml")
+	contents = "[Main]\nCommunityToken = 'community-token'\n" + contents
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// writeTestSecret writes a secret file for a _FILE environment variable and returns its path.
+func writeTestSecret(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadEnvPrecedence(t *testing.T) {
+	tests := []struct {
+		name          string
+		file          string
+		secretFile    string
+		env           string
+		wantToken     string
+		wantKeepAlive int
+	}{
+		{
+			name:          "file",
+			file:          "UserToken = 'file-token'\nStorageKeepAlive = 1200\n",
+			wantToken:     "file-token",
+			wantKeepAlive: 1200,
+		},
+		{
+			name:          "default",
+			file:          "UserToken = 'file-token'\n",
+			wantToken:     "file-token",
+			wantKeepAlive: DefaultBotConfiguration().Main.StorageKeepAlive,
+		},
+		{
+			name:          "_FILE over file",
+			file:          "UserToken = 'file-token'\nStorageKeepAlive = 1200\n",
+			secretFile:    "secret-token\r\n",
+			wantToken:     "secret-token",
+			wantKeepAlive: 1200,
+		},
+		{
+			name:          "env over _FILE",
+			file:          "UserToken = 'file-token'\nStorageKeepAlive = 1200\n",
+			secretFile:    "secret-token\n",
+			env:           "env-token",
+			wantToken:     "env-token",
+			wantKeepAlive: 1200,
+		},
+		{
+			name:          "env over default",
+			env:           "env-token",
+			wantToken:     "env-token",
+			wantKeepAlive: DefaultBotConfiguration().Main.StorageKeepAlive,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.secretFile != "" {
+				t.Setenv("OTLOZHKA_MAIN_USERTOKEN_FILE", writeTestSecret(t, test.secretFile))
+			}
+			if test.env != "" {
+				t.Setenv("OTLOZHKA_MAIN_USERTOKEN", test.env)
+			}
+			cfg, err := Load(writeTestConfig(t, test.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Main.UserToken != test.wantToken {
+				t.Errorf("Main.UserToken = %q, want %q", cfg.Main.UserToken, test.wantToken)
+			}
+			if cfg.Main.StorageKeepAlive != test.wantKeepAlive {
+				t.Errorf("Main.StorageKeepAlive = %d, want %d", cfg.Main.StorageKeepAlive, test.wantKeepAlive)
+			}
+		})
+	}
+}
+
+func TestLoadEnvNonStringFields(t *testing.T) {
+	t.Setenv("OTLOZHKA_MAIN_USERTOKEN", "env-token")
+	t.Setenv("OTLOZHKA_MAIN_STORAGEKEEPALIVE", "1800")
+	t.Setenv("OTLOZHKA_MAIN_STORAGEREFRESHINTERVAL", "300")
+	t.Setenv("OTLOZHKA_REMINDERS_ENABLED", "true")
+	t.Setenv("OTLOZHKA_REMINDERS_LEADTIMES", "[120, 30]")
+	t.Setenv("OTLOZHKA_SCHEDULE_REPORTPEERIDS_FILE", writeTestSecret(t, "[2000000001, 2000000002]\n"))
+	t.Setenv("OTLOZHKA_KEYBOARD_LABELS", "{ otlozhka = 'Мои посты', help = 'Помощь' }")
+	t.Setenv("OTLOZHKA_CHATS",
+		"[{ PeerID = 2000000001, AllowedCommands = ['otlozhka', 'help'], ReplyPrivately = true }]")
+
+	// Values set in the file get replaced, not merged
+	cfg, err := Load(writeTestConfig(t, "StorageKeepAlive = 1200\n[Keyboard.Labels]\ncalendar = 'Календарь'\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Main.StorageKeepAlive != 1800 {
+		t.Errorf("Main.StorageKeepAlive = %d, want 1800", cfg.Main.StorageKeepAlive)
+	}
+	if cfg.Main.StorageRefreshInterval != 300 {
+		t.Errorf("Main.StorageRefreshInterval = %d, want 300", cfg.Main.StorageRefreshInterval)
+	}
+	if !cfg.Reminders.Enabled {
+		t.Error("Reminders.Enabled = false, want true")
+	}
+	if want := []int{120, 30}; !slices.Equal(cfg.Reminders.LeadTimes, want) {
+		t.Errorf("Reminders.LeadTimes = %v, want %v", cfg.Reminders.LeadTimes, want)
+	}
+	if want := []int{2000000001, 2000000002}; !slices.Equal(cfg.Schedule.ReportPeerIDs, want) {
+		t.Errorf("Schedule.ReportPeerIDs = %v, want %v", cfg.Schedule.ReportPeerIDs, want)
+	}
+	if want := map[string]string{"otlozhka": "Мои посты", "help": "Помощь"}; !maps.Equal(cfg.Keyboard.Labels, want) {
+		t.Errorf("Keyboard.Labels = %v, want %v", cfg.Keyboard.Labels, want)
+	}
+	wantChats := []ChatConfiguration{
+		{PeerID: 2000000001, AllowedCommands: []string{"otlozhka", "help"}, ReplyPrivately: true},
+	}
+	if !slices.EqualFunc(cfg.Chats, wantChats, func(a, b ChatConfiguration) bool {
+		return a.PeerID == b.PeerID && slices.Equal(a.AllowedCommands, b.AllowedCommands) &&
+			a.Staff == b.Staff && a.ReplyPrivately == b.ReplyPrivately
+	}) {
+		t.Errorf("Chats = %+v, want %+v", cfg.Chats, wantChats)
+	}
+}
+
+func TestLoadEnvMalformedValues(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{"OTLOZHKA_MAIN_STORAGEKEEPALIVE", "fifteen minutes"},
+		{"OTLOZHKA_MAIN_STORAGEKEEPALIVE", "'900'"},
+		{"OTLOZHKA_MAIN_STORAGEKEEPALIVE", "1.5"},
+		{"OTLOZHKA_REMINDERS_ENABLED", "yes"},
+		{"OTLOZHKA_REMINDERS_LEADTIMES", "[120, 'thirty']"},
+		{"OTLOZHKA_REMINDERS_LEADTIMES", "120, 30"},
+		{"OTLOZHKA_KEYBOARD_LABELS", "{ otlozhka = }"},
+		{"OTLOZHKA_CHATS", "[{ PeerID = 'chat' }]"},
+		{"OTLOZHKA_CHATS", "[{"},
+	}
+	for _, test := range tests {
+		t.Run(test.name+"="+test.value, func(t *testing.T) {
+			t.Setenv("OTLOZHKA_MAIN_USERTOKEN", "env-token")
+			t.Setenv(test.name, test.value)
+			_, err := Load(writeTestConfig(t, ""))
+			if err == nil {
+				t.Fatal("Load succeeded, want an error")
+			}
+			if !strings.Contains(err.Error(), test.name) {
+				t.Errorf("error %q doesn't name %s", err, test.name)
+			}
+		})
+	}
+
+	t.Run("missing _FILE", func(t *testing.T) {
+		t.Setenv("OTLOZHKA_MAIN_USERTOKEN_FILE", filepath.Join(t.TempDir(), "missing"))
+		_, err := Load(writeTestConfig(t, ""))
+		if err == nil {
+			t.Fatal("Load succeeded, want an error")
+		}
+		if !strings.Contains(err.Error(), "OTLOZHKA_MAIN_USERTOKEN_FILE") {
+			t.Errorf("error %q doesn't name OTLOZHKA_MAIN_USERTOKEN_FILE", err)
+		}
+	})
+}
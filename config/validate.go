@@ -0,0 +1,318 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+// VK API caps on requests per second.
+const (
+	maxCommunityAPIRateLimit = 20
+	maxUserAPIRateLimit      = 3
+)
+
+// validator collects every configuration problem found, so they can all be reported at once.
+type validator struct {
+	errs []error
+}
+
+// check records a problem with a field unless `ok` holds.
+func (v *validator) check(ok bool, field, format string, args ...any) {
+	if !ok {
+		v.errs = append(v.errs, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+	}
+}
+
+// checkRange records a problem with a field whose value is out of [minValue, maxValue].
+func (v *validator) checkRange(value, minValue, maxValue int, field string) {
+	v.check(value >= minValue && value <= maxValue, field, "%d is out of range [%d, %d]", value, minValue, maxValue)
+}
+
+// checkMin records a problem with a field whose value is below minValue.
+func (v *validator) checkMin(value, minValue int, field string) {
+	v.check(value >= minValue, field, "%d must be at least %d", value, minValue)
+}
+
+// checkNotEmpty records a problem with an empty string field.
+func (v *validator) checkNotEmpty(value, field string) {
+	v.check(value != "", field, "must not be empty")
+}
+
+// checkFormat records a problem with a format string field which doesn't take exactly the values of `args` kinds,
+// or formats one of them with a verb not suited for its kind, such as %d for a string.
+func (v *validator) checkFormat(format string, args []formatArg, field string) {
+	verbs := parseFormatVerbs(format)
+	formatted := make([]bool, len(args))
+	for _, verb := range verbs {
+		if verb.arg >= 0 && verb.arg < len(args) {
+			formatted[verb.arg] = true
+		}
+	}
+	if slices.Contains(formatted, false) || slices.ContainsFunc(verbs, func(verb formatVerb) bool {
+		return verb.arg < 0 || verb.arg >= len(args)
+	}) {
+		v.check(false, field, "%q has %d formatting verbs (like %%s), expected %d", format, len(verbs), len(args))
+		return
+	}
+	for _, verb := range verbs {
+		arg := args[verb.arg]
+		v.check(strings.IndexByte(formatArgVerbs[arg], verb.verb) != -1, field,
+			"%q formats value %d, which is %s, with %%%c; expected one of %%%s", format, verb.arg+1, arg, verb.verb,
+			strings.Join(strings.Split(formatArgVerbs[arg], ""), ", %"))
+	}
+}
+
+// checkCommandName records a problem with a field naming a command which doesn't exist.
+func (v *validator) checkCommandName(name, field string) {
+	v.check(slices.Contains(CommandNames, name), field, "%q is not a command, expected one of %s",
+		name, strings.Join(CommandNames, ", "))
+}
+
+// checkMessages records a problem with an empty pool of messages, which one would be picked from at random.
+func (v *validator) checkMessages(messages []string, field string) {
+	v.check(len(messages) > 0, field, "must hold at least one message")
+}
+
+// checkClock records a problem with a time of day field not in "HH:MM" format. Returns the parsed time.
+func (v *validator) checkClock(clock, field string) (time.Time, bool) {
+	parsed, err := time.Parse("15:04", clock)
+	v.check(err == nil, field, "%q is not a time of day in HH:MM format", clock)
+	return parsed, err == nil
+}
+
+// compileRegex compiles a regular expression field, recording a problem if it doesn't compile.
+func (v *validator) compileRegex(expr, field string) *regexp.Regexp {
+	re, err := regexp.Compile(expr)
+	v.check(err == nil, field, "%v", err)
+	return re
+}
+
+// formatArg is the kind of a value passed to a format string from the configuration.
+type formatArg int
+
+const (
+	stringArg formatArg = iota
+	intArg
+)
+
+// String names the kind of value in problem descriptions.
+func (arg formatArg) String() string {
+	if arg == intArg {
+		return "a number"
+	}
+	return "a string"
+}
+
+// formatArgVerbs lists fmt verbs suited for every kind of value.
+var formatArgVerbs = map[formatArg]string{
+	stringArg: "sqvxX",
+	intArg:    "dvbcoOqxXU",
+}
+
+// formatVerb is a formatting verb in a fmt format string, along with the zero-based index of the value it formats.
+type formatVerb struct {
+	verb byte
+	arg  int
+}
+
+// parseFormatVerbs returns formatting verbs in a fmt format string, "%%" not being a verb.
+// Values are taken in order, unless an explicit index like "%[2]s" is given.
+func parseFormatVerbs(format string) []formatVerb {
+	var verbs []formatVerb
+	arg := 0
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			continue
+		}
+		// Skipping flags, width and precision, taking explicit value indexes into account
+		i++
+		for i < len(format) && strings.IndexByte("+-# 0123456789.*[]", format[i]) != -1 {
+			if format[i] == '[' {
+				if end := strings.IndexByte(format[i:], ']'); end != -1 {
+					if index, err := strconv.Atoi(format[i+1 : i+end]); err == nil {
+						arg = index - 1
+					}
+					i += end
+				}
+			}
+			i++
+		}
+		if i < len(format) && format[i] != '%' {
+			verbs = append(verbs, formatVerb{verb: format[i], arg: arg})
+			arg++
+		}
+	}
+	return verbs
+}
+
+// unknownKeyErrors turns keys missing in BotConfiguration, reported by the strict TOML decoder, into problems.
+func unknownKeyErrors(strictErr *toml.StrictMissingError) []error {
+	errs := make([]error, 0, len(strictErr.Errors))
+	for _, decodeErr := range strictErr.Errors {
+		line, _ := decodeErr.Position()
+		errs = append(errs, fmt.Errorf("%s: unknown key on line %d", strings.Join(decodeErr.Key(), "."), line))
+	}
+	return errs
+}
+
+// sortedKeys returns keys of a map in ascending order, so problems are reported in a stable order.
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Validate checks the configuration for every problem which would make the bot fail or misbehave later,
+// and compiles regular expressions of command triggers. Returns all problems found joined into a single error,
+// or nil if there are none.
+func (config *BotConfiguration) Validate() error {
+	v := &validator{}
+
+	main := config.Main
+	v.checkNotEmpty(main.UserToken, "Main.UserToken")
+	v.checkNotEmpty(main.CommunityToken, "Main.CommunityToken")
+	v.checkRange(main.CommunityAPIRateLimit, 1, maxCommunityAPIRateLimit, "Main.CommunityAPIRateLimit")
+	v.checkRange(main.UserAPIRateLimit, 1, maxUserAPIRateLimit, "Main.UserAPIRateLimit")
+	v.checkMin(main.StorageKeepAlive, 1, "Main.StorageKeepAlive")
+	v.checkMin(main.StorageRefreshInterval, 0, "Main.StorageRefreshInterval")
+	if main.StorageRefreshInterval > 0 {
+		v.check(main.StorageRefreshInterval < main.StorageKeepAlive, "Main.StorageRefreshInterval",
+			"%d must be less than Main.StorageKeepAlive %d, or requests would wait for updates",
+			main.StorageRefreshInterval, main.StorageKeepAlive)
+	}
+	v.checkMin(main.StorageRefreshJitter, 0, "Main.StorageRefreshJitter")
+	v.checkMin(main.StorageRefreshMaxBackoff, 1, "Main.StorageRefreshMaxBackoff")
+	if main.StorageRefreshInterval > 0 {
+		v.check(main.StorageRefreshMaxBackoff < main.StorageKeepAlive, "Main.StorageRefreshMaxBackoff",
+			"%d must be less than Main.StorageKeepAlive %d, or stale posts would be served between retries",
+			main.StorageRefreshMaxBackoff, main.StorageKeepAlive)
+	}
+	v.check(main.EventsMode == EventsModeLongPoll || main.EventsMode == EventsModeCallback, "Main.EventsMode",
+		"%q is unknown, expected %q or %q", main.EventsMode, EventsModeLongPoll, EventsModeCallback)
+	v.checkMin(main.ShutdownTimeout, 1, "Main.ShutdownTimeout")
+
+	if logs := config.ZerologConfig; logs.FileLoggingEnabled {
+		v.checkNotEmpty(logs.Filename, "ZerologConfig.Filename")
+		v.checkMin(logs.MaxSize, 1, "ZerologConfig.MaxSize")
+		v.checkMin(logs.MaxBackups, 0, "ZerologConfig.MaxBackups")
+		v.checkMin(logs.MaxAge, 0, "ZerologConfig.MaxAge")
+	}
+
+	builder := config.MessageBuilder
+	_, err := time.LoadLocation(builder.Timezone)
+	v.check(err == nil, "MessageBuilder.Timezone", "%v", err)
+	v.checkFormat(builder.MessageFormat, []formatArg{stringArg, stringArg}, "MessageBuilder.MessageFormat")
+	v.checkNotEmpty(builder.TimeFormat, "MessageBuilder.TimeFormat")
+	v.checkMin(builder.CalendarDaysPerPage, 0, "MessageBuilder.CalendarDaysPerPage")
+	if builder.CalendarDaysPerPage > 0 {
+		v.checkFormat(builder.CalendarPageFormat, []formatArg{intArg, intArg}, "MessageBuilder.CalendarPageFormat")
+	}
+
+	handler := config.MessageHandler
+	config.CompiledRegexes = CompiledRegexes{
+		Otlozhka:      v.compileRegex(handler.OtlozhkaRegex, "MessageHandler.OtlozhkaRegex"),
+		UpdateStorage: v.compileRegex(handler.UpdateStorageRegex, "MessageHandler.UpdateStorageRegex"),
+		PrintStorage:  v.compileRegex(handler.PrintStorageRegex, "MessageHandler.PrintStorageRegex"),
+		Help:          v.compileRegex(handler.HelpRegex, "MessageHandler.HelpRegex"),
+		Schedule:      v.compileRegex(handler.ScheduleRegex, "MessageHandler.ScheduleRegex"),
+		FreeSlots:     v.compileRegex(handler.FreeSlotsRegex, "MessageHandler.FreeSlotsRegex"),
+		ICalendar:     v.compileRegex(handler.ICalendarRegex, "MessageHandler.ICalendarRegex"),
+		Export:        v.compileRegex(handler.ExportRegex, "MessageHandler.ExportRegex"),
+	}
+	v.checkMessages(handler.StorageUpdatedMsgs, "MessageHandler.StorageUpdatedMsgs")
+	v.checkMessages(handler.StorageUpdatedCommendMsgs, "MessageHandler.StorageUpdatedCommendMsgs")
+	v.checkMessages(handler.StorageEmptyMsgs, "MessageHandler.StorageEmptyMsgs")
+	v.checkMessages(handler.CalendarNothingFoundMsgs, "MessageHandler.CalendarNothingFoundMsgs")
+	v.checkMessages(handler.CalendarFilterInvalidMsgs, "MessageHandler.CalendarFilterInvalidMsgs")
+	v.checkMessages(handler.PostponedPostsFoundMsgs, "MessageHandler.PostponedPostsFoundMsgs")
+	v.checkMessages(handler.NoPostponedPostsFoundMsgs, "MessageHandler.NoPostponedPostsFoundMsgs")
+	v.checkMessages(handler.ErrorMsgs, "MessageHandler.ErrorMsgs")
+
+	v.checkFormat(config.Help.CommandFormat, []formatArg{stringArg, stringArg}, "Help.CommandFormat")
+	for _, name := range sortedKeys(config.Help.Descriptions) {
+		v.checkCommandName(name, fmt.Sprintf("Help.Descriptions[%q]", name))
+	}
+	for _, name := range sortedKeys(config.Keyboard.Labels) {
+		v.checkCommandName(name, fmt.Sprintf("Keyboard.Labels[%q]", name))
+	}
+
+	if main.EventsMode == EventsModeCallback {
+		callback := config.Callback
+		v.checkNotEmpty(callback.Address, "Callback.Address")
+		v.check(strings.HasPrefix(callback.Path, "/"), "Callback.Path", "%q must start with /", callback.Path)
+		v.checkNotEmpty(callback.ConfirmationKey, "Callback.ConfirmationKey")
+		// Without the secret key anyone knowing the address could forge events, running commands as a manager
+		v.checkNotEmpty(callback.SecretKey, "Callback.SecretKey")
+	}
+
+	for i, chat := range config.Chats {
+		v.check(chat.PeerID > 2000000000, fmt.Sprintf("Chats[%d].PeerID", i),
+			"%d is not a chat peer ID, expected 2000000000 + chat number", chat.PeerID)
+		for j, name := range chat.AllowedCommands {
+			v.checkCommandName(name, fmt.Sprintf("Chats[%d].AllowedCommands[%d]", i, j))
+		}
+	}
+
+	if notifications := config.Notifications; notifications.Enabled {
+		v.checkFormat(notifications.RescheduledMsgFormat, []formatArg{stringArg, stringArg},
+			"Notifications.RescheduledMsgFormat")
+		v.checkFormat(notifications.DeletedMsgFormat, []formatArg{stringArg}, "Notifications.DeletedMsgFormat")
+	}
+
+	if reminders := config.Reminders; reminders.Enabled {
+		v.check(len(reminders.LeadTimes) > 0, "Reminders.LeadTimes", "must hold at least one lead time")
+		for i, leadTime := range reminders.LeadTimes {
+			v.checkMin(leadTime, 1, fmt.Sprintf("Reminders.LeadTimes[%d]", i))
+		}
+		v.checkMin(reminders.CheckInterval, 1, "Reminders.CheckInterval")
+		v.checkFormat(reminders.ReminderMsgFormat, []formatArg{stringArg}, "Reminders.ReminderMsgFormat")
+	}
+
+	schedule := config.Schedule
+	v.checkMin(schedule.CollisionWindow, 0, "Schedule.CollisionWindow")
+	v.checkMin(schedule.MaxGap, 1, "Schedule.MaxGap")
+	v.checkMin(schedule.LookaheadDays, 1, "Schedule.LookaheadDays")
+	v.checkFormat(schedule.HeaderFormat, []formatArg{stringArg}, "Schedule.HeaderFormat")
+	v.checkFormat(schedule.GapsHeaderFormat, []formatArg{intArg}, "Schedule.GapsHeaderFormat")
+	if schedule.ReportEnabled {
+		v.checkClock(schedule.ReportTime, "Schedule.ReportTime")
+		v.check(len(schedule.ReportPeerIDs) > 0, "Schedule.ReportPeerIDs", "must hold at least one peer ID")
+	}
+
+	slots := config.Slots
+	gridStart, startOK := v.checkClock(slots.GridStart, "Slots.GridStart")
+	gridEnd, endOK := v.checkClock(slots.GridEnd, "Slots.GridEnd")
+	if startOK && endOK {
+		v.check(!gridEnd.Before(gridStart), "Slots.GridEnd", "%q is before Slots.GridStart %q",
+			slots.GridEnd, slots.GridStart)
+	}
+	v.checkMin(slots.GridInterval, 1, "Slots.GridInterval")
+	v.checkMin(slots.SuggestCount, 1, "Slots.SuggestCount")
+	v.checkMin(slots.LookaheadDays, 1, "Slots.LookaheadDays")
+
+	iCalendar := config.ICalendar
+	if iCalendar.ServerEnabled {
+		v.checkNotEmpty(iCalendar.Address, "ICalendar.Address")
+		v.check(strings.HasPrefix(iCalendar.Path, "/"), "ICalendar.Path", "%q must start with /", iCalendar.Path)
+	}
+	v.checkMin(iCalendar.EventDuration, 0, "ICalendar.EventDuration")
+	v.checkNotEmpty(iCalendar.Filename, "ICalendar.Filename")
+
+	export := config.Export
+	v.check(export.DefaultFormat == "csv" || export.DefaultFormat == "json", "Export.DefaultFormat",
+		"%q is unknown, expected \"csv\" or \"json\"", export.DefaultFormat)
+	v.checkNotEmpty(export.Filename, "Export.Filename")
+
+	return errors.Join(v.errs...)
+}
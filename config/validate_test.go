@@ -0,0 +1,126 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+// validTestConfig returns the default configuration with tokens set, which is valid.
+func validTestConfig() BotConfiguration {
+	cfg := DefaultBotConfiguration()
+	cfg.Main.UserToken = "user-token"
+	cfg.Main.CommunityToken = "community-token"
+	return cfg
+}
+
+func TestValidateDefaults(t *testing.T) {
+	cfg := validTestConfig()
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidateCommandNames(t *testing.T) {
+	cfg := validTestConfig()
+	cfg.Chats = []ChatConfiguration{
+		{PeerID: 2000000001, AllowedCommands: []string{CommandOtlozhka, "calender", CommandHelp, "Help"}},
+	}
+	cfg.Keyboard.Labels = map[string]string{CommandOtlozhka: "Мои посты", "slot": "Слоты"}
+	cfg.Help.Descriptions = map[string]string{"icalendar": "Календарь", CommandExport: "Экспорт"}
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("Validate succeeded, want an error")
+	}
+	problems := strings.Split(err.Error(), "\n")
+	wantFields := []string{
+		`Help.Descriptions["icalendar"]`,
+		`Keyboard.Labels["slot"]`,
+		"Chats[0].AllowedCommands[1]",
+		"Chats[0].AllowedCommands[3]",
+	}
+	if len(problems) != len(wantFields) {
+		t.Fatalf("got %d problems, want %d:\n%v", len(problems), len(wantFields), err)
+	}
+	for _, field := range wantFields {
+		if !strings.Contains(err.Error(), field+": ") {
+			t.Errorf("no problem reported with %s:\n%v", field, err)
+		}
+	}
+}
+
+func TestValidateFormatVerbs(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []formatArg
+		format  string
+		wantErr bool
+	}{
+		{"strings", []formatArg{stringArg, stringArg}, "%s: %q", false},
+		{"number", []formatArg{intArg}, "Перерывы дольше %d ч.", false},
+		{"percent sign", []formatArg{intArg}, "%d%% posts", false},
+		{"explicit indexes", []formatArg{intArg, intArg}, "%[2]d / %[1]d", false},
+		{"repeated value", []formatArg{stringArg}, "%[1]s (%[1]v)", false},
+		{"number as string", []formatArg{intArg}, "Перерывы дольше %s ч.", true},
+		{"string as number", []formatArg{stringArg}, "%d", true},
+		{"too few verbs", []formatArg{intArg, intArg}, "Страница %d", true},
+		{"too many verbs", []formatArg{intArg}, "%d %d", true},
+		{"missing value", []formatArg{intArg, intArg}, "%[2]d", true},
+		{"unknown verb", []formatArg{stringArg}, "%я", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			v := &validator{}
+			v.checkFormat(test.format, test.args, "Field")
+			if gotErr := len(v.errs) > 0; gotErr != test.wantErr {
+				t.Errorf("checkFormat(%q) problems %v, want error %v", test.format, v.errs, test.wantErr)
+			}
+		})
+	}
+
+	cfg := validTestConfig()
+	cfg.Schedule.GapsHeaderFormat = "Перерывы дольше %s ч."
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "Schedule.GapsHeaderFormat") {
+		t.Errorf("Validate() = %v, want a problem with Schedule.GapsHeaderFormat", err)
+	}
+}
+
+func TestValidateCallbackSecretKey(t *testing.T) {
+	cfg := validTestConfig()
+	cfg.Main.EventsMode = EventsModeCallback
+	cfg.Callback.ConfirmationKey = "confirmation"
+	err := cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), "Callback.SecretKey") {
+		t.Errorf("Validate() = %v, want a problem with Callback.SecretKey", err)
+	}
+
+	cfg.Callback.SecretKey = "secret"
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Validate() = %v, want nil", err)
+	}
+}
+
+func TestLoadUnknownKeys(t *testing.T) {
+	path := writeTestConfig(t, "UserTokn = 'user-token'\n[Mian]\nUserToken = 'user-token'\n"+
+		"[[Chats]]\nPeerID = 2000000001\nAllowedComands = ['help']\n")
+	_, err := Load(path)
+	if err == nil {
+		t.Fatal("Load succeeded, want an error")
+	}
+	for _, problem := range []string{
+		"Main.UserTokn: unknown key on line 3",
+		"Mian: unknown key on line 4",
+		"Chats.AllowedComands: unknown key on line 8",
+		"Main.UserToken: must not be empty",
+	} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("error doesn't report %q:\n%v", problem, err)
+		}
+	}
+
+	t.Setenv("OTLOZHKA_CHATS", "[{ PeerID = 2000000001, AllowedComands = ['help'] }]")
+	_, err = Load(writeTestConfig(t, "UserToken = 'user-token'\n"))
+	if err == nil || !strings.Contains(err.Error(), "AllowedComands: unknown key") {
+		t.Errorf("Load() = %v, want an unknown key in OTLOZHKA_CHATS", err)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	_ "embed"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// defaultConfigFile is the configuration file with default parameters, commented.
+//
+//go:embed config_example.toml
+var defaultConfigFile []byte
+
+// writeDefaultConfig writes the commented configuration file with default parameters to `path`.
+func writeDefaultConfig(path string) error {
+	if err := os.WriteFile(path, defaultConfigFile, 0644); err != nil {
+		return fmt.Errorf("writing default configuration to %s: %w", path, err)
+	}
+	return nil
+}
+
+// runInitConfigCommand runs the "init-config" subcommand, writing the commented configuration file
+// with default parameters. An existing file is only overwritten with -force.
+//
+//	otlozhka-bot [-config file] init-config [-force]
+func runInitConfigCommand(configFilename string, args []string) error {
+	flags := flag.NewFlagSet("init-config", flag.ContinueOnError)
+	force := flags.Bool("force", false, "Overwrite an existing configuration file")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if _, err := os.Stat(configFilename); err == nil && !*force {
+		return fmt.Errorf("%s already exists, pass -force to overwrite it", configFilename)
+	} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := writeDefaultConfig(configFilename); err != nil {
+		return err
+	}
+	fmt.Printf("Default configuration written to %s\n", configFilename)
+	return nil
+}
+
+// runCheckConfigCommand runs the "check-config" subcommand, validating the configuration file
+// with environment variables applied. Every problem found is reported.
+//
+//	otlozhka-bot [-config file] check-config
+func runCheckConfigCommand(configFilename string, args []string) error {
+	flags := flag.NewFlagSet("check-config", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if _, err := loadConfigFile(configFilename); err != nil {
+		return err
+	}
+	fmt.Printf("%s: OK\n", configFilename)
+	return nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/alphatoasterous/otlozhka-bot/config"
+)
+
+func TestDefaultConfigFileMatchesDefaults(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := writeDefaultConfig(path); err != nil {
+		t.Fatal(err)
+	}
+	// Tokens have no defaults, so the file is only valid with them set
+	t.Setenv("OTLOZHKA_MAIN_USERTOKEN", "user-token")
+	t.Setenv("OTLOZHKA_MAIN_COMMUNITYTOKEN", "community-token")
+	loaded, err := config.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defaults := config.DefaultBotConfiguration()
+	defaults.Main.UserToken = "user-token"
+	defaults.Main.CommunityToken = "community-token"
+	if changed := config.ChangedSections(&defaults, loaded); len(changed) > 0 {
+		t.Errorf("sections %v of the default configuration file differ from DefaultBotConfiguration()", changed)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/alphatoasterous/otlozhka-bot/api_utils"
+	"github.com/alphatoasterous/otlozhka-bot/handlers"
+)
+
+// runExportCommand runs the "export" subcommand, writing every postponed post to a CSV or JSON file.
+// Posts are fetched from VK; if that fails, posts from the storage snapshot are exported instead.
+//
+//	otlozhka-bot [-config file] export [-format csv|json] [-output file]
+func runExportCommand(configFilename string, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := flags.String("format", "", `Export format: csv or json (default Export.DefaultFormat)`)
+	output := flags.String("output", "", `Output file, "-" for standard output (default "<Export.Filename>.<format>")`)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	cfg, logger, err := loadCommandConfig(configFilename)
+	if err != nil {
+		return err
+	}
+	defer closeCommandLogger(logger)
+	exportConfig := cfg.Export
+	if *format == "" {
+		*format = exportConfig.DefaultFormat
+	}
+	if *format != api_utils.ExportFormatCSV && *format != api_utils.ExportFormatJSON {
+		return fmt.Errorf("unknown export format %q", *format)
+	}
+	if *output == "" {
+		*output = exportConfig.Filename + "." + *format
+	}
+
+	botConfig := cfg.Main
+	vkCommunity, vkUser := newAPIClients(botConfig, logger.Logger)
+	group, err := api_utils.GetGroupInfo(vkCommunity)
+	if err != nil {
+		return err
+	}
+	fetcher := handlers.NewVKWallpostFetcher(vkUser, group.ScreenName, logger.Logger)
+	// The storage has no snapshot path, so exporting never overwrites the snapshot of a running bot
+	storage := handlers.NewWallpostStorage(fetcher, int64(botConfig.StorageKeepAlive), "", logger.Logger)
+	if _, err := storage.UpdateWallpostStorage(); err != nil {
+		snapshot := handlers.NewWallpostStorage(fetcher, int64(botConfig.StorageKeepAlive),
+			botConfig.StorageSnapshotPath, logger.Logger)
+		snapshotLoaded, loadErr := snapshot.LoadSnapshot()
+		if loadErr != nil {
+			logger.Error().Err(loadErr).Msg("Failed to load wallpost storage snapshot")
+		}
+		if !snapshotLoaded {
+			return fmt.Errorf("updating wallpost storage: %w", err)
+		}
+		logger.Warn().Err(err).Msg("Failed to update wallpost storage, exporting posts from snapshot")
+		storage = snapshot
+	}
+
+	data, err := handlers.ExportStorage(cfg, storage, *format)
+	if err != nil {
+		return err
+	}
+	if *output == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	if err := os.WriteFile(*output, data, 0644); err != nil {
+		return err
+	}
+	logger.Info().Str("path", *output).Int("posts", len(storage.GetWallposts())).Msg("Posts exported")
+	return nil
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/alphatoasterous/otlozhka-bot/api_utils"
+)
+
+// calendarDateLayout is the date format of canonical calendar filter arguments.
+const calendarDateLayout = "02.01.2006"
+
+var (
+	// Dates like "20.10" or "20.10.2024", optionally followed by a dash and another date, making a range
+	calendarDateRangeRegex = regexp.MustCompile(
+		`(\d{1,2})\.(\d{1,2})(?:\.(\d{4}|\d{2}))?(?:\s*[-–—]\s*(\d{1,2})\.(\d{1,2})(?:\.(\d{4}|\d{2}))?)?`)
+	// User mentions, either as VK formats them ("[id123|Name]") or typed by hand ("@id123", "id123")
+	calendarSignerRegex = regexp.MustCompile(`\[id(\d+)\|[^\]]*\]|@?\bid(\d+)\b`)
+)
+
+// calendarRelativeDays maps words for relative dates to day offsets from today.
+var calendarRelativeDays = map[string]int{
+	"сегодня":     0,
+	"завтра":      1,
+	"послезавтра": 2,
+}
+
+var errInvalidCalendarDate = errors.New("invalid calendar date")
+
+// ParseCalendarFilter parses calendar command arguments into a filter.
+// Arguments may hold a date ("20.10", "20.10.2024"), a date range ("20.10–27.10"), a relative date
+// ("сегодня", "завтра", "послезавтра") and a mention of a post author ("@id123"). Other words are ignored.
+// Dates are taken in `loc`; dates without a year refer to the current year, or to the next one
+// if they have already passed, since postponed posts are never in the past.
+func ParseCalendarFilter(args string, now time.Time, loc *time.Location) (api_utils.CalendarFilter, error) {
+	var filter api_utils.CalendarFilter
+	now = now.In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+
+	if match := calendarSignerRegex.FindStringSubmatch(args); match != nil {
+		signerID, err := strconv.Atoi(match[1] + match[2])
+		if err != nil {
+			return filter, fmt.Errorf("parsing signer ID: %w", err)
+		}
+		filter.SignerID = signerID
+		args = strings.Replace(args, match[0], " ", 1)
+	}
+
+	if match := calendarDateRangeRegex.FindStringSubmatch(args); match != nil {
+		from, err := parseCalendarDate(match[1], match[2], match[3], today.AddDate(0, 0, 1-today.YearDay()), loc)
+		if err != nil {
+			return filter, err
+		}
+		to := from
+		if match[4] != "" {
+			to, err = parseCalendarDate(match[4], match[5], match[6], from, loc)
+			if err != nil {
+				return filter, err
+			}
+			if to.Before(from) {
+				return filter, fmt.Errorf("%w: range ends before it starts", errInvalidCalendarDate)
+			}
+		}
+		if match[3] == "" && match[6] == "" && to.Before(today) {
+			from, to = from.AddDate(1, 0, 0), to.AddDate(1, 0, 0)
+		}
+		filter.From, filter.To = from, to.AddDate(0, 0, 1)
+		return filter, nil
+	}
+
+	for _, word := range strings.FieldsFunc(args, func(r rune) bool { return !unicode.IsLetter(r) }) {
+		if offset, found := calendarRelativeDays[word]; found {
+			filter.From = today.AddDate(0, 0, offset)
+			filter.To = filter.From.AddDate(0, 0, 1)
+			break
+		}
+	}
+	return filter, nil
+}
+
+// parseCalendarDate builds a date from its day, month and optional year. A missing year is the year of `notBefore`,
+// or the next one if the date would be before `notBefore`; two-digit years belong to the 21st century.
+func parseCalendarDate(dayStr, monthStr, yearStr string, notBefore time.Time,
+	loc *time.Location) (time.Time, error) {
+	day, _ := strconv.Atoi(dayStr)
+	month, _ := strconv.Atoi(monthStr)
+	year := notBefore.Year()
+	if yearStr != "" {
+		year, _ = strconv.Atoi(yearStr)
+		if year < 100 {
+			year += 2000
+		}
+	}
+	date := time.Date(year, time.Month(month), day, 0, 0, 0, 0, loc)
+	// time.Date normalizes dates like 31.02, which are rejected instead
+	if date.Day() != day || int(date.Month()) != month {
+		return time.Time{}, fmt.Errorf("%w: %s.%s", errInvalidCalendarDate, dayStr, monthStr)
+	}
+	if yearStr == "" && date.Before(notBefore) {
+		date = date.AddDate(1, 0, 0)
+	}
+	return date, nil
+}
+
+// formatCalendarFilterArgs formats a filter as canonical calendar command arguments, which ParseCalendarFilter
+// parses back into the same filter. Relative dates get resolved, so the result can be stored in button payloads.
+func formatCalendarFilterArgs(filter api_utils.CalendarFilter, loc *time.Location) string {
+	var args []string
+	if !filter.From.IsZero() && !filter.To.IsZero() {
+		args = append(args, filter.From.In(loc).Format(calendarDateLayout)+"-"+
+			filter.To.In(loc).AddDate(0, 0, -1).Format(calendarDateLayout))
+	}
+	if filter.SignerID != 0 {
+		args = append(args, "id"+strconv.Itoa(filter.SignerID))
+	}
+	return strings.Join(args, " ")
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alphatoasterous/otlozhka-bot/api_utils"
+)
+
+func TestParseCalendarFilter(t *testing.T) {
+	loc := time.FixedZone("MSK", 3*60*60)
+	// Already October 20 in the configured timezone, still October 19 in UTC
+	now := time.Date(2024, time.October, 19, 22, 30, 0, 0, time.UTC)
+	date := func(day int, month time.Month, year int) time.Time {
+		return time.Date(year, month, day, 0, 0, 0, 0, loc)
+	}
+	days := func(from, to time.Time) api_utils.CalendarFilter {
+		return api_utils.CalendarFilter{From: from, To: to}
+	}
+
+	tests := []struct {
+		args    string
+		want    api_utils.CalendarFilter
+		wantErr bool
+	}{
+		{args: "", want: api_utils.CalendarFilter{}},
+		{args: "все посты", want: api_utils.CalendarFilter{}},
+		{args: "сегодня", want: days(date(20, time.October, 2024), date(21, time.October, 2024))},
+		{args: "завтра", want: days(date(21, time.October, 2024), date(22, time.October, 2024))},
+		{args: "послезавтра", want: days(date(22, time.October, 2024), date(23, time.October, 2024))},
+		{args: "20.10", want: days(date(20, time.October, 2024), date(21, time.October, 2024))},
+		{args: "19.10", want: days(date(19, time.October, 2025), date(20, time.October, 2025))},
+		{args: "15.01.2023", want: days(date(15, time.January, 2023), date(16, time.January, 2023))},
+		{args: "20.10–27.10", want: days(date(20, time.October, 2024), date(28, time.October, 2024))},
+		{args: "28.12 - 05.01", want: days(date(28, time.December, 2024), date(6, time.January, 2025))},
+		{args: "01.11.2024-03.11.24", want: days(date(1, time.November, 2024), date(4, time.November, 2024))},
+		{args: "@id123 завтра", want: api_utils.CalendarFilter{
+			From: date(21, time.October, 2024), To: date(22, time.October, 2024), SignerID: 123}},
+		{args: "[id45|Автор] 20.10", want: api_utils.CalendarFilter{
+			From: date(20, time.October, 2024), To: date(21, time.October, 2024), SignerID: 45}},
+		{args: "id7", want: api_utils.CalendarFilter{SignerID: 7}},
+		{args: "31.02", wantErr: true},
+		{args: "32.10", wantErr: true},
+		{args: "10.13", wantErr: true},
+		{args: "20.10.2024-19.10.2024", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.args, func(t *testing.T) {
+			filter, err := ParseCalendarFilter(test.args, now, loc)
+			if test.wantErr {
+				if !errors.Is(err, errInvalidCalendarDate) {
+					t.Errorf("ParseCalendarFilter(%q) error = %v, want %v", test.args, err, errInvalidCalendarDate)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !equalCalendarFilters(filter, test.want) {
+				t.Errorf("ParseCalendarFilter(%q) = %+v, want %+v", test.args, filter, test.want)
+			}
+
+			// Canonical arguments, stored in page buttons, parse back into the same filter
+			args := formatCalendarFilterArgs(filter, loc)
+			if parsed, err := ParseCalendarFilter(args, now, loc); err != nil || !equalCalendarFilters(parsed, filter) {
+				t.Errorf("ParseCalendarFilter(%q) = %+v, %v, want %+v", args, parsed, err, filter)
+			}
+		})
+	}
+}
+
+func equalCalendarFilters(a, b api_utils.CalendarFilter) bool {
+	return a.From.Equal(b.From) && a.To.Equal(b.To) && a.SignerID == b.SignerID
+}
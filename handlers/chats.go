@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"slices"
+
+	"github.com/alphatoasterous/otlozhka-bot/config"
+)
+
+// Command names, used in chat rules to allow commands. They're defined by config, so the configuration
+// can be checked for unknown names.
+const (
+	CommandOtlozhka      = config.CommandOtlozhka
+	CommandUpdateStorage = config.CommandUpdateStorage
+	CommandPrintStorage  = config.CommandPrintStorage
+	CommandHelp          = config.CommandHelp
+	CommandSchedule      = config.CommandSchedule
+	CommandFreeSlots     = config.CommandFreeSlots
+	CommandICalendar     = config.CommandICalendar
+	CommandExport        = config.CommandExport
+)
+
+// chatPeerIDOffset is added by VK to a chat number to get its peer ID.
+const chatPeerIDOffset = 2000000000
+
+// isChat reports whether a peer ID belongs to a group chat rather than to a private dialog.
+func isChat(peerID int) bool {
+	return peerID > chatPeerIDOffset
+}
+
+// getPeerRules returns rules for a given peer from configured `chats` rules.
+// Private dialogs allow every command, managers still being the only ones to run manager commands.
+// Chats missing from the configuration only allow the "otlozhka" command, answered in the chat.
+func getPeerRules(peerID int, chats []config.ChatConfiguration) config.ChatConfiguration {
+	if !isChat(peerID) {
+		return config.ChatConfiguration{
+			PeerID:          peerID,
+			AllowedCommands: slices.Clone(config.CommandNames),
+		}
+	}
+	for _, chat := range chats {
+		if chat.PeerID == peerID {
+			return chat
+		}
+	}
+	return config.ChatConfiguration{
+		PeerID:          peerID,
+		AllowedCommands: []string{CommandOtlozhka},
+	}
+}
+
+// canRunCommand checks whether a command may be run by a sender under given peer rules.
+// Manager commands require the sender to be a community manager, unless the chat is a staff chat.
+func canRunCommand(rules config.ChatConfiguration, command string, managerOnly, isManager bool) bool {
+	if !slices.Contains(rules.AllowedCommands, command) {
+		return false
+	}
+	return !managerOnly || isManager || rules.Staff
+}
@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/alphatoasterous/otlozhka-bot/api_utils"
+	"github.com/alphatoasterous/otlozhka-bot/config"
+	"github.com/alphatoasterous/otlozhka-bot/utils"
+	"github.com/rs/zerolog"
+)
+
+// commendAddedPostsThreshold is the amount of newly postponed posts, found by a manager-requested storage update,
+// for which the manager gets thanked for their work.
+const commendAddedPostsThreshold = 10
+
+// newCommandRouter creates a Router with every bot command registered, triggered by given regular expressions.
+// Manager commands go first, so a manager's message is not mistaken for an author's request.
+// Routers are cheap to build, so one is built for every message with the configuration in effect.
+func newCommandRouter(regexes config.CompiledRegexes, logger zerolog.Logger) *Router {
+	router := NewRouter(logger)
+	router.Register(Command{
+		Name:      CommandUpdateStorage,
+		Triggers:  []*regexp.Regexp{regexes.UpdateStorage},
+		Role:      RoleManager,
+		PeerTypes: PeerAny,
+		Handler:   handleUpdateStorage,
+	})
+	router.Register(Command{
+		Name:      CommandPrintStorage,
+		Triggers:  []*regexp.Regexp{regexes.PrintStorage},
+		Role:      RoleManager,
+		PeerTypes: PeerAny,
+		Handler:   handlePrintStorage,
+	})
+	router.Register(Command{
+		Name:      CommandSchedule,
+		Triggers:  []*regexp.Regexp{regexes.Schedule},
+		Role:      RoleManager,
+		PeerTypes: PeerAny,
+		Handler:   handleScheduleCheck,
+	})
+	router.Register(Command{
+		Name:      CommandICalendar,
+		Triggers:  []*regexp.Regexp{regexes.ICalendar},
+		Role:      RoleManager,
+		PeerTypes: PeerAny,
+		Handler:   handleICalendarExport,
+	})
+	router.Register(Command{
+		Name:      CommandExport,
+		Triggers:  []*regexp.Regexp{regexes.Export},
+		Role:      RoleManager,
+		PeerTypes: PeerAny,
+		Handler:   handleExport,
+	})
+	router.Register(Command{
+		Name:          CommandOtlozhka,
+		Triggers:      []*regexp.Regexp{regexes.Otlozhka},
+		Role:          RoleAuthor,
+		PeerTypes:     PeerAny,
+		PersonalReply: true,
+		Handler:       handleOtlozhka,
+	})
+	router.Register(Command{
+		Name:          CommandFreeSlots,
+		Triggers:      []*regexp.Regexp{regexes.FreeSlots},
+		Role:          RoleAuthor,
+		PeerTypes:     PeerAny,
+		PersonalReply: true,
+		Handler:       handleFreeSlots,
+	})
+	router.Register(Command{
+		Name:          CommandHelp,
+		Triggers:      []*regexp.Regexp{regexes.Help},
+		Role:          RoleAuthor,
+		PeerTypes:     PeerAny,
+		PersonalReply: true,
+		Handler:       handleHelp,
+	})
+	return router
+}
+
+// handleUpdateStorage updates the storage on a manager request and reports back,
+// thanking the manager if a lot of new postponed posts were found.
+func handleUpdateStorage(ctx *CommandContext) error {
+	diff, err := ctx.Storage.UpdateWallpostStorage()
+	if err != nil {
+		return fmt.Errorf("updating wallpost storage: %w", err)
+	}
+	messages := ctx.Config.MessageHandler
+	var text string
+	if len(diff.Added) >= commendAddedPostsThreshold {
+		text = utils.GetRandomItemFromStrArray(messages.StorageUpdatedCommendMsgs)
+	} else {
+		text = utils.GetRandomItemFromStrArray(messages.StorageUpdatedMsgs)
+	}
+	return ctx.Reply(text)
+}
+
+// handlePrintStorage sends a calendar of stored posts on a manager request.
+// The calendar may be limited to a date range or a post author, given after the command trigger
+// (e.g. "календарь завтра", "календарь 20.10–27.10", "календарь @id123").
+// If calendar pagination is configured, a single page is sent with buttons leading to adjacent pages;
+// pressing a callback button edits the calendar message in place.
+func handlePrintStorage(ctx *CommandContext) error {
+	messages, messageBuilder := ctx.Config.MessageHandler, ctx.Config.MessageBuilder
+	updateStorageIfStale(ctx.Storage)
+	posts := ctx.Storage.GetWallposts()
+	if len(posts) == 0 {
+		return ctx.Reply(utils.GetRandomItemFromStrArray(messages.StorageEmptyMsgs))
+	}
+
+	loc, err := time.LoadLocation(messageBuilder.Timezone)
+	if err != nil {
+		return fmt.Errorf("loading timezone: %w", err)
+	}
+	args := ctx.Payload.Args
+	if ctx.Payload.Command == "" {
+		args = getCommandArgs(ctx.Text, ctx.Config.CompiledRegexes.PrintStorage)
+	}
+	filter, err := ParseCalendarFilter(args, time.Now(), loc)
+	if err != nil {
+		ctx.Logger.Debug().Err(err).Str("args", args).Msg("Invalid calendar filter")
+		return ctx.Reply(utils.GetRandomItemFromStrArray(messages.CalendarFilterInvalidMsgs))
+	}
+
+	if messageBuilder.CalendarDaysPerPage <= 0 {
+		responseMessage, err := api_utils.GetFormattedCalendar(posts, messageBuilder.Timezone, filter)
+		if err != nil {
+			return fmt.Errorf("formatting calendar: %w", err)
+		}
+		if responseMessage == "" {
+			responseMessage = utils.GetRandomItemFromStrArray(messages.CalendarNothingFoundMsgs)
+		}
+		return ctx.Reply(responseMessage)
+	}
+
+	calendarPage, page, pageCount, err := api_utils.GetFormattedCalendarPage(posts, messageBuilder.Timezone, filter,
+		ctx.Payload.Page, messageBuilder.CalendarDaysPerPage)
+	if err != nil {
+		return fmt.Errorf("formatting calendar: %w", err)
+	}
+	if calendarPage == "" {
+		return ctx.ReplyOrEdit(utils.GetRandomItemFromStrArray(messages.CalendarNothingFoundMsgs),
+			newCommandKeyboard(ctx))
+	}
+	responseMessage := fmt.Sprintf(messageBuilder.CalendarPageFormat, page+1, pageCount) + "\n\n" + calendarPage
+	navigationRow := newPageNavigationRow(ctx, CommandPrintStorage, formatCalendarFilterArgs(filter, loc),
+		page, pageCount)
+	return ctx.ReplyOrEdit(responseMessage, newCommandKeyboard(ctx, navigationRow))
+}
+
+// getCommandArgs returns the part of a message text following the command trigger.
+func getCommandArgs(text string, trigger *regexp.Regexp) string {
+	loc := trigger.FindStringIndex(text)
+	if loc == nil {
+		return ""
+	}
+	return strings.TrimSpace(text[loc[1]:])
+}
+
+// handleOtlozhka sends the sender every postponed post they have authored.
+// Senders without postponed posts may get free publication slots suggested instead.
+// Posts are sent to the reply peer, which is either the peer the request came from, or the sender themselves.
+func handleOtlozhka(ctx *CommandContext) error {
+	updateStorageIfStale(ctx.Storage)
+	posts := ctx.Storage.GetWallposts()
+	foundPosts := GetWallpostsByPeerID(ctx.Message.FromID, posts)
+	if len(foundPosts) != 0 {
+		return messageFoundPosts(ctx.ReplyPeerID, ctx.Sender, ctx.Config.MessageBuilder, foundPosts,
+			ctx.Config.MessageHandler.PostponedPostsFoundMsgs)
+	}
+	text := utils.GetRandomItemFromStrArray(ctx.Config.MessageHandler.NoPostponedPostsFoundMsgs)
+	if ctx.Config.Slots.SuggestWhenNothingFound {
+		freeSlots, err := getFormattedFreeSlots(ctx.Config, ctx.Storage, time.Now())
+		if err != nil {
+			return err
+		}
+		text += "\n\n" + freeSlots
+	}
+	return ctx.Reply(text)
+}
+
+// handleHelp lists commands the sender may run from this peer, with their descriptions and example phrasings.
+// Examples are built from command trigger regular expressions.
+func handleHelp(ctx *CommandContext) error {
+	help := ctx.Config.Help
+	lines := []string{help.Header}
+	for _, command := range ctx.Router.AvailableCommands(ctx) {
+		description, found := help.Descriptions[command.Name]
+		if !found {
+			description = command.Name
+		}
+		var example string
+		if len(command.Triggers) > 0 && command.Triggers[0] != nil {
+			example = utils.GetRegexExample(command.Triggers[0])
+		}
+		lines = append(lines, fmt.Sprintf(help.CommandFormat, description, example))
+	}
+	return ctx.Reply(strings.Join(lines, "\n"))
+}
@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/alphatoasterous/otlozhka-bot/api_utils"
+	"github.com/alphatoasterous/otlozhka-bot/config"
+)
+
+// ExportStorage writes every stored post in the given format ("csv" or "json"),
+// with publication dates in the configured timezone.
+func ExportStorage(cfg *config.BotConfiguration, storage *WallpostStorage, format string) ([]byte, error) {
+	loc, err := time.LoadLocation(cfg.MessageBuilder.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("loading timezone: %w", err)
+	}
+	var buffer bytes.Buffer
+	if err := api_utils.ExportPosts(&buffer, storage.GetWallposts(), loc, format); err != nil {
+		return nil, fmt.Errorf("exporting posts: %w", err)
+	}
+	return buffer.Bytes(), nil
+}
+
+// getExportFormat picks the export format named in a message text, falling back to the configured one.
+func getExportFormat(text, defaultFormat string) string {
+	switch {
+	case strings.Contains(text, api_utils.ExportFormatJSON):
+		return api_utils.ExportFormatJSON
+	case strings.Contains(text, api_utils.ExportFormatCSV):
+		return api_utils.ExportFormatCSV
+	default:
+		return defaultFormat
+	}
+}
+
+// handleExport sends a manager every stored post as a CSV or JSON document.
+func handleExport(ctx *CommandContext) error {
+	updateStorageIfStale(ctx.Storage)
+	export := ctx.Config.Export
+	format := getExportFormat(ctx.Text, export.DefaultFormat)
+	data, err := ExportStorage(ctx.Config, ctx.Storage, format)
+	if err != nil {
+		return err
+	}
+	return ctx.ReplyDocument(export.DocumentMsg, export.Filename+"."+format, data)
+}
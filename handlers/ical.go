@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/SevereCloud/vksdk/v2/api"
+	"github.com/alphatoasterous/otlozhka-bot/api_utils"
+	"github.com/alphatoasterous/otlozhka-bot/config"
+	"github.com/rs/zerolog"
+)
+
+// buildICalendar generates an iCalendar file of every stored post, looking post author names up
+// via the `*api.VK` client with Community access. Author names are left out if the lookup fails.
+func buildICalendar(cfg *config.BotConfiguration, storage *WallpostStorage, vkCommunity *api.VK,
+	logger zerolog.Logger) []byte {
+	iCalendar := cfg.ICalendar
+	posts := storage.GetWallposts()
+	var signerIDs []int
+	seen := make(map[int]bool)
+	for _, post := range posts {
+		if post.SignerID > 0 && !seen[post.SignerID] {
+			seen[post.SignerID] = true
+			signerIDs = append(signerIDs, post.SignerID)
+		}
+	}
+	authorNames, err := api_utils.GetUserNames(vkCommunity, signerIDs)
+	if err != nil {
+		logger.Warn().Err(err).Msg("iCalendar: Failed to get author names")
+	}
+	return api_utils.GetICalendar(posts, authorNames, iCalendar.CalendarName,
+		time.Duration(iCalendar.EventDuration)*time.Minute, time.Now())
+}
+
+// handleICalendarExport sends a manager the iCalendar file of stored posts as a document.
+func handleICalendarExport(ctx *CommandContext) error {
+	updateStorageIfStale(ctx.Storage)
+	data := buildICalendar(ctx.Config, ctx.Storage, ctx.VKCommunity, ctx.Logger)
+	return ctx.ReplyDocument(ctx.Config.ICalendar.DocumentMsg, ctx.Config.ICalendar.Filename, data)
+}
+
+// NewICalendarHTTPHandler creates an HTTP handler serving the iCalendar file of stored posts,
+// so calendar applications can subscribe to it. If `token` is not empty, requests must pass it
+// as the "token" query parameter. The storage gets updated, if it is stale.
+// The file is built with the configuration currently held by `configStore`.
+func NewICalendarHTTPHandler(configStore *config.Store, storage *WallpostStorage, vkCommunity *api.VK,
+	token string, logger zerolog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		if token != "" && subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("token")), []byte(token)) != 1 {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		updateStorageIfStale(storage)
+		cfg := configStore.Current()
+		data := buildICalendar(cfg, storage, vkCommunity, logger)
+		w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", cfg.ICalendar.Filename))
+		if _, err := w.Write(data); err != nil {
+			logger.Warn().Err(err).Msg("iCalendar: Failed to write response")
+		}
+	})
+}
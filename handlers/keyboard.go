@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"github.com/SevereCloud/vksdk/v2/api"
+	"github.com/SevereCloud/vksdk/v2/events"
+	"github.com/SevereCloud/vksdk/v2/object"
+	"github.com/alphatoasterous/otlozhka-bot/api_utils"
+	"github.com/alphatoasterous/otlozhka-bot/config"
+	"github.com/rs/zerolog"
+)
+
+// ButtonPayload is attached to keyboard buttons and names the command a button runs.
+type ButtonPayload struct {
+	Command string `json:"command"`
+	// Zero-based page of paginated command answers, such as the calendar
+	Page int `json:"page,omitempty"`
+	// Command arguments, which would otherwise follow the command trigger in a message
+	Args string `json:"args,omitempty"`
+}
+
+// parseButtonPayload parses a button payload. Returns false if the payload doesn't name a command.
+func parseButtonPayload(payload []byte) (ButtonPayload, bool) {
+	var buttonPayload ButtonPayload
+	if len(payload) == 0 || json.Unmarshal(payload, &buttonPayload) != nil || buttonPayload.Command == "" {
+		return ButtonPayload{}, false
+	}
+	return buttonPayload, true
+}
+
+// supportsCallbackButtons reports whether the sender's VK client supports callback buttons.
+func supportsCallbackButtons(clientInfo object.ClientInfo) bool {
+	return slices.Contains(clientInfo.ButtonActions, object.ButtonCallback)
+}
+
+// newCommandKeyboard creates an inline keyboard with buttons for commands available in given context.
+// Only commands with a configured label get a button. Extra rows, such as page navigation, go above command buttons.
+// Returns nil if keyboards are disabled.
+func newCommandKeyboard(ctx *CommandContext, extraRows ...[]api_utils.KeyboardButton) *object.MessagesKeyboard {
+	keyboard := ctx.Config.Keyboard
+	if !keyboard.Enabled || ctx.Router == nil {
+		return nil
+	}
+	var buttons []api_utils.KeyboardButton
+	for _, command := range ctx.Router.AvailableCommands(ctx) {
+		if label, found := keyboard.Labels[command.Name]; found && label != "" {
+			buttons = append(buttons, api_utils.KeyboardButton{
+				Label:   label,
+				Payload: ButtonPayload{Command: command.Name},
+			})
+		}
+	}
+	return api_utils.CreateInlineKeyboard(append(extraRows, buttons), ctx.CallbackButtons)
+}
+
+// newPageNavigationRow creates a row of buttons leading to the previous and the next page of a command answer,
+// run with the same arguments. Buttons are omitted on the first and the last pages.
+func newPageNavigationRow(ctx *CommandContext, command, args string, page, pageCount int) []api_utils.KeyboardButton {
+	keyboard := ctx.Config.Keyboard
+	var row []api_utils.KeyboardButton
+	if page > 0 {
+		row = append(row, api_utils.KeyboardButton{
+			Label:   keyboard.PreviousPageLabel,
+			Payload: ButtonPayload{Command: command, Page: page - 1, Args: args},
+		})
+	}
+	if page < pageCount-1 {
+		row = append(row, api_utils.KeyboardButton{
+			Label:   keyboard.NextPageLabel,
+			Payload: ButtonPayload{Command: command, Page: page + 1, Args: args},
+		})
+	}
+	return row
+}
+
+// Reply sends a text answer to the reply peer, along with a keyboard of commands available to the sender.
+// Long answers are split into several messages, the keyboard being attached to the last one.
+func (ctx *CommandContext) Reply(text string) error {
+	return sendText(ctx.Sender, ctx.ReplyPeerID, text, newCommandKeyboard(ctx))
+}
+
+// ReplyDocument uploads data as a document and sends it to the reply peer with a text,
+// along with a keyboard of commands available to the sender.
+func (ctx *CommandContext) ReplyDocument(text, filename string, data []byte) error {
+	attachment, err := api_utils.UploadMessageDocument(ctx.VKCommunity, ctx.ReplyPeerID, filename, data)
+	if err != nil {
+		return err
+	}
+	msg := api_utils.CreateMessageSendBuilderDocument(text, attachment)
+	msg.PeerID(ctx.ReplyPeerID)
+	if keyboard := newCommandKeyboard(ctx); keyboard != nil {
+		msg.Keyboard(keyboard)
+	}
+	if _, err := ctx.Sender.MessagesSend(msg.Params); err != nil {
+		return fmt.Errorf("sending document %s: %w", filename, err)
+	}
+	return nil
+}
+
+// ReplyOrEdit edits the message whose callback button ran the command, replacing its text and keyboard.
+// If the command wasn't run by a callback button, or the answer goes to another peer, a new message is sent instead.
+// Edited text is truncated to a single message.
+func (ctx *CommandContext) ReplyOrEdit(text string, keyboard *object.MessagesKeyboard) error {
+	if ctx.EditableMessageID == 0 || ctx.ReplyPeerID != ctx.Message.PeerID {
+		return sendText(ctx.Sender, ctx.ReplyPeerID, text, keyboard)
+	}
+	msg := api_utils.CreateMessageEditBuilderText(ctx.Message.PeerID, ctx.EditableMessageID, text)
+	if keyboard != nil {
+		msg.Keyboard(keyboard)
+	}
+	if _, err := ctx.Sender.MessagesEdit(msg.Params); err != nil {
+		return fmt.Errorf("editing message %d: %w", ctx.EditableMessageID, err)
+	}
+	return nil
+}
+
+// MessageEventHandler processes callback button presses from the message event.
+// The button payload names a command, which is run the same way as if it was triggered by a message.
+// The button press is always answered, so the VK client stops waiting for it.
+func MessageEventHandler(obj events.MessageEventObject, configStore *config.Store, vkCommunity *api.VK,
+	groupManagerIDs []int, storage *WallpostStorage, logger zerolog.Logger) {
+	_, err := vkCommunity.MessagesSendMessageEventAnswer(api.Params{
+		"event_id": obj.EventID,
+		"user_id":  obj.UserID,
+		"peer_id":  obj.PeerID,
+	})
+	if err != nil {
+		logger.Warn().Err(err).Int("peerID", obj.PeerID).Msg("Failed to answer message event")
+	}
+
+	payload, found := parseButtonPayload(obj.Payload)
+	if !found {
+		logger.Warn().Int("peerID", obj.PeerID).Str("payload", string(obj.Payload)).
+			Msg("Message event without a command")
+		return
+	}
+	message := object.MessagesMessage{
+		PeerID:                obj.PeerID,
+		FromID:                obj.UserID,
+		ConversationMessageID: obj.ConversationMessageID,
+	}
+	ctx := newCommandContext(message, configStore, vkCommunity, groupManagerIDs, storage, logger)
+	ctx.CallbackButtons = true
+	ctx.Payload = payload
+	ctx.EditableMessageID = obj.ConversationMessageID
+	if _, err := ctx.Router.DispatchCommand(payload.Command, ctx); err != nil {
+		reportError(ctx, err)
+	}
+}
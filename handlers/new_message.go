@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"fmt"
 	"slices"
 	"strings"
 
@@ -9,106 +10,117 @@ import (
 	"github.com/SevereCloud/vksdk/v2/object"
 	"github.com/alphatoasterous/otlozhka-bot/api_utils"
 	"github.com/alphatoasterous/otlozhka-bot/config"
-	"github.com/alphatoasterous/otlozhka-bot/logging"
 	"github.com/alphatoasterous/otlozhka-bot/utils"
+	"github.com/rs/zerolog"
 )
 
-var messages = config.BotConfig.MessageHandler
-var regexes = config.BotConfig.CompiledRegexes
-
-// messageFoundPosts sends post messages to a specific peerID using the `*api.VK` client with Community access.
-// If predefined messages are available, it sends one at random. Then it sends details of each
-// found post in `foundPosts` to the same peerID. Each operation logs and handles errors critically.
-func messageFoundPosts(peerID int, vkCommunity *api.VK, foundPosts []object.WallWallpost) {
-	if len(messages.PostponedPostsFoundMsgs) != 0 { // if post found messages are defined
-		message := api_utils.CreateMessageSendBuilderText(
-			utils.GetRandomItemFromStrArray(messages.PostponedPostsFoundMsgs)) // send random message to user
+// sendText sends a text message to a specific peerID using `sender`, the `*api.VK` client with Community access.
+// Text too long for a single message is sent in several messages, in order.
+// If `keyboard` is not nil, it's attached to the last message.
+func sendText(sender MessageSender, peerID int, text string, keyboard *object.MessagesKeyboard) error {
+	builders := api_utils.CreateMessageSendBuildersText(text)
+	for i, message := range builders {
 		message.PeerID(peerID)
-		_, err := vkCommunity.MessagesSend(message.Params)
-		if err != nil {
-			logging.Log.Fatal().Err(err)
+		if keyboard != nil && i == len(builders)-1 {
+			message.Keyboard(keyboard)
 		}
-	}
-	for _, post := range foundPosts {
-		msg := api_utils.CreateMessageSendBuilderByPost(post)
-		msg.PeerID(peerID)
-		_, err := vkCommunity.MessagesSend(msg.Params)
-		if err != nil {
-			logging.Log.Fatal().Err(err)
+		if _, err := sender.MessagesSend(message.Params); err != nil {
+			return fmt.Errorf("sending message %d of %d to %d: %w", i+1, len(builders), peerID, err)
 		}
 	}
+	return nil
 }
 
-// NewMessageHandler processes incoming messages from the new message event.
-// It checks the origin of the message, updates storage, or sends specific responses based on command recognition.
-// The function distinguishes between different message origins and contents to update wall post storage
-// or respond accordingly, employing regular expressions for command detection.
-func NewMessageHandler(obj events.MessageNewObject, vkCommunity *api.VK,
-	vkUser *api.VK, domain string, groupManagerIDs []int, storage *WallpostStorage) {
-	const communityChatID = 2000000004 // Community group chat
-	incomingMessageText := strings.ToLower(obj.Message.Text)
-	if obj.Message.PeerID != communityChatID { // Checks if message camen't from community group chat
-		if slices.Contains(groupManagerIDs, obj.Message.PeerID) { // If message came from community management
-			switch {
-			case regexes.UpdateStorage.MatchString(incomingMessageText):
-				logging.Log.Debug().Msgf("Update storage message[id%d]: %s", obj.Message.PeerID, obj.Message.Text)
-				previousWallpostCount := storage.GetWallpostCount()
-				storage.UpdateWallpostStorage(vkUser, domain)
-				message := api_utils.CreateMessageSendBuilderText("")
-				if storage.GetWallpostCount()-previousWallpostCount >= 10 {
-					message.Message(utils.GetRandomItemFromStrArray(messages.StorageUpdatedCommendMsgs))
-				} else {
-					message.Message(utils.GetRandomItemFromStrArray(messages.StorageUpdatedMsgs))
-				}
-				message.PeerID(obj.Message.PeerID)
-				_, err := vkCommunity.MessagesSend(message.Params)
-				if err != nil {
-					logging.Log.Fatal().Err(err)
-				}
-			case regexes.PrintStorage.MatchString(incomingMessageText):
-				logging.Log.Debug().Msgf("Print storage message[id%d]: %s", obj.Message.PeerID, obj.Message.Text)
-				if storage.CheckWallpostStorageNeedsUpdate() {
-					storage.UpdateWallpostStorage(vkUser, domain)
-				}
-				var responseMessage string
-				var err error
-				if storage.GetWallpostCount() > 0 {
-					responseMessage, err = api_utils.GetFormattedCalendar(storage.GetWallposts(), "Europe/Moscow")
-					if err != nil {
-						logging.Log.Fatal().Err(err)
-					}
-				} else {
-					responseMessage = utils.GetRandomItemFromStrArray(messages.StorageEmptyMsgs)
-				}
-				message := api_utils.CreateMessageSendBuilderText(responseMessage)
-				message.PeerID(obj.Message.PeerID)
-				_, err = vkCommunity.MessagesSend(message.Params)
-				if err != nil {
-					logging.Log.Fatal().Err(err)
-				}
+// messageFoundPosts sends post messages to a specific peerID using `sender`.
+// If predefined messages `foundMsgs` are available, it sends one at random. Then it sends details of each
+// found post in `foundPosts` to the same peerID, formatted as configured in `builder`.
+// Returns the first error encountered.
+func messageFoundPosts(peerID int, sender MessageSender, builder config.MessageBuilderConfiguration,
+	foundPosts []object.WallWallpost, foundMsgs []string) error {
+	if len(foundMsgs) != 0 { // if post found messages are defined
+		// send random message to user, unless it's empty
+		if text := utils.GetRandomItemFromStrArray(foundMsgs); text != "" {
+			if err := sendText(sender, peerID, text, nil); err != nil {
+				return err
 			}
 		}
-
 	}
-
-	switch {
-	case regexes.Otlozhka.MatchString(incomingMessageText):
-		logging.Log.Printf("Incoming message[id%d]: %s", obj.Message.PeerID, obj.Message.Text)
-		if storage.CheckWallpostStorageNeedsUpdate() {
-			storage.UpdateWallpostStorage(vkUser, domain)
-		}
-		posts := storage.GetWallposts()
-		foundPosts := GetWallpostsByPeerID(obj.Message.PeerID, posts)
-		if len(foundPosts) != 0 {
-			messageFoundPosts(obj.Message.PeerID, vkCommunity, foundPosts)
-		} else {
-			message := api_utils.CreateMessageSendBuilderText(
-				utils.GetRandomItemFromStrArray(messages.NoPostponedPostsFoundMsgs))
-			message.PeerID(obj.Message.PeerID)
-			_, err := vkCommunity.MessagesSend(message.Params)
-			if err != nil {
-				logging.Log.Fatal().Err(err)
+	for _, post := range foundPosts {
+		for _, msg := range api_utils.CreateMessageSendBuildersByPost(builder, post) {
+			msg.PeerID(peerID)
+			if _, err := sender.MessagesSend(msg.Params); err != nil {
+				return fmt.Errorf("sending post %d to %d: %w", post.ID, peerID, err)
 			}
 		}
 	}
+	return nil
+}
+
+// updateStorageIfStale updates the storage if its posts are stale and no WallpostRefresher keeps them up to date.
+// While a refresher runs, a request never blocks on VK API calls: if background updates keep failing
+// for longer than the storage keep-alive time, stale posts are served as they are, the refresher retrying with backoff.
+// Without a refresher the request updates the storage, unless posts are being fetched already and the storage
+// holds posts to serve meanwhile. If the update fails, stale posts are kept and served, so the error is only logged.
+func updateStorageIfStale(storage *WallpostStorage) {
+	if !storage.CheckWallpostStorageNeedsUpdate() {
+		return
+	}
+	if storage.refreshedInBackground.Load() {
+		storage.logger.Warn().Msg("Wallpost storage is stale, serving stale posts until background refresh succeeds")
+		return
+	}
+	if _, err := storage.UpdateWallpostStorageIfStale(); err != nil {
+		storage.logger.Warn().Err(err).Msg("Failed to update stale wallpost storage, serving stale posts")
+	}
+}
+
+// newCommandContext builds a CommandContext for an incoming message, handled with the configuration
+// currently held by `configStore`. Commands log to `logger`.
+func newCommandContext(message object.MessagesMessage, configStore *config.Store, vkCommunity *api.VK,
+	groupManagerIDs []int, storage *WallpostStorage, logger zerolog.Logger) *CommandContext {
+	cfg := configStore.Current()
+	return &CommandContext{
+		Message:     message,
+		Config:      cfg,
+		Text:        strings.ToLower(message.Text),
+		ReplyPeerID: message.PeerID,
+		IsManager:   slices.Contains(groupManagerIDs, message.FromID), // If message came from community management
+		Rules:       getPeerRules(message.PeerID, cfg.Chats),
+		VKCommunity: vkCommunity,
+		Sender:      vkCommunity,
+		Storage:     storage,
+		Router:      newCommandRouter(cfg.CompiledRegexes, logger),
+		Logger:      logger,
+	}
+}
+
+// reportError logs an error of handling a message and tells the sender something went wrong.
+func reportError(ctx *CommandContext, err error) {
+	ctx.Logger.Error().Err(err).Int("peerID", ctx.Message.PeerID).Int("fromID", ctx.Message.FromID).
+		Str("text", ctx.Message.Text).Msg("Failed to handle message")
+	if err := sendText(ctx.Sender, ctx.Message.PeerID,
+		utils.GetRandomItemFromStrArray(ctx.Config.MessageHandler.ErrorMsgs), nil); err != nil {
+		ctx.Logger.Error().Err(err).Int("peerID", ctx.Message.PeerID).Msg("Failed to report error to user")
+	}
+}
+
+// NewMessageHandler processes incoming messages from the new message event.
+// The message is dispatched to the first command it triggers, which the sender is allowed to run from this peer.
+// Messages sent with keyboard text buttons run the command named in the button payload instead.
+// Commands available in group chats are limited by chat rules from the configuration.
+// If handling a message fails, the error is logged to `logger` and the sender is told something went wrong.
+func NewMessageHandler(obj events.MessageNewObject, configStore *config.Store, vkCommunity *api.VK,
+	groupManagerIDs []int, storage *WallpostStorage, logger zerolog.Logger) {
+	ctx := newCommandContext(obj.Message, configStore, vkCommunity, groupManagerIDs, storage, logger)
+	ctx.CallbackButtons = supportsCallbackButtons(obj.ClientInfo)
+	var err error
+	if payload, found := parseButtonPayload([]byte(obj.Message.Payload)); found {
+		ctx.Payload = payload
+		_, err = ctx.Router.DispatchCommand(payload.Command, ctx)
+	} else {
+		_, err = ctx.Router.Dispatch(ctx)
+	}
+	if err != nil {
+		reportError(ctx, err)
+	}
 }
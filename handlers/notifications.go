@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/SevereCloud/vksdk/v2/api"
+	"github.com/SevereCloud/vksdk/v2/api/params"
+	"github.com/alphatoasterous/otlozhka-bot/api_utils"
+	"github.com/alphatoasterous/otlozhka-bot/config"
+	"github.com/rs/zerolog"
+)
+
+// NewAuthorNotifier creates a WallpostStorage update listener, which messages post authors
+// when their postponed post gets rescheduled, or gets removed from postponed posts without being published.
+// Only posts signed by a user are taken into account. Messages are sent using the `*api.VK` client with Community access,
+// formatted with the configuration currently held by `configStore`. Nothing is sent while notifications are disabled
+// by a reloaded configuration, whose formats aren't validated then.
+func NewAuthorNotifier(configStore *config.Store, vkCommunity *api.VK, logger zerolog.Logger) func(diff WallpostDiff) {
+	return func(diff WallpostDiff) {
+		cfg := configStore.Current()
+		notifications := cfg.Notifications
+		if !notifications.Enabled {
+			logger.Debug().Msg("Notifications are disabled in configuration, skipping update")
+			return
+		}
+		for _, change := range diff.Rescheduled {
+			if change.Current.SignerID <= 0 {
+				continue
+			}
+			logger.Info().Int("signerID", change.Current.SignerID).Int("postID", change.Current.ID).
+				Int("previousDate", change.Previous.Date).Int("date", change.Current.Date).
+				Msg("Notifying author of a rescheduled post")
+			msgs := api_utils.CreateMessageSendBuildersByRescheduledPost(cfg.MessageBuilder,
+				notifications.RescheduledMsgFormat, change.Previous, change.Current)
+			if err := sendAuthorNotification(vkCommunity, change.Current.SignerID, msgs); err != nil {
+				logger.Warn().Err(err).Int("peerID", change.Current.SignerID).Msg("Failed to notify author")
+			}
+		}
+		for _, post := range diff.Deleted {
+			if post.SignerID <= 0 {
+				continue
+			}
+			logger.Info().Int("signerID", post.SignerID).Int("postID", post.ID).
+				Msg("Notifying author of a deleted post")
+			msgs := api_utils.CreateMessageSendBuildersByDeletedPost(cfg.MessageBuilder,
+				notifications.DeletedMsgFormat, post)
+			if err := sendAuthorNotification(vkCommunity, post.SignerID, msgs); err != nil {
+				logger.Warn().Err(err).Int("peerID", post.SignerID).Msg("Failed to notify author")
+			}
+		}
+	}
+}
+
+// sendAuthorNotification sends notification messages to an author, in order.
+// Authors may not allow messages from the community, which isPermanentSendError tells apart.
+func sendAuthorNotification(vkCommunity *api.VK, peerID int, msgs []*params.MessagesSendBuilder) error {
+	for i, msg := range msgs {
+		msg.PeerID(peerID)
+		if _, err := vkCommunity.MessagesSend(msg.Params); err != nil {
+			return fmt.Errorf("notifying author %d, message %d of %d: %w", peerID, i+1, len(msgs), err)
+		}
+	}
+	return nil
+}
+
+// isPermanentSendError reports whether a message failed to send because the recipient can't be messaged
+// by the community at all, so sending it again is pointless.
+func isPermanentSendError(err error) bool {
+	return errors.Is(err, api.ErrMessagesDenySend) || errors.Is(err, api.ErrMessagesPrivacy) ||
+		errors.Is(err, api.ErrMessagesUserBlocked) || errors.Is(err, api.ErrUserDeleted)
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// refresherInitialBackoff is the delay before the first retry after a failed background update.
+// Every following failure doubles it, up to the configured maximum backoff.
+const refresherInitialBackoff = time.Second * 30
+
+// WallpostRefresher keeps WallpostStorage warm by updating it in background on a fixed interval,
+// so user requests never have to wait for postponed posts to be fetched.
+type WallpostRefresher struct {
+	storage *WallpostStorage
+	logger  zerolog.Logger
+
+	interval   time.Duration
+	jitter     time.Duration
+	maxBackoff time.Duration
+}
+
+// NewWallpostRefresher creates a WallpostRefresher for the given storage.
+// The storage is updated every `interval` plus a random delay of up to `jitter`.
+// After a failed update the refresher retries with exponential backoff, capped at `maxBackoff`,
+// or at `interval` if `maxBackoff` is not set.
+func NewWallpostRefresher(storage *WallpostStorage, interval, jitter, maxBackoff time.Duration,
+	logger zerolog.Logger) *WallpostRefresher {
+	return &WallpostRefresher{
+		storage:    storage,
+		logger:     logger,
+		interval:   interval,
+		jitter:     jitter,
+		maxBackoff: maxBackoff,
+	}
+}
+
+// Run updates the storage until ctx is cancelled. It blocks, so it should be started in its own goroutine.
+// While it runs, requests finding the storage stale serve stored posts instead of updating it.
+func (refresher *WallpostRefresher) Run(ctx context.Context) {
+	refresher.storage.refreshedInBackground.Store(true)
+	defer refresher.storage.refreshedInBackground.Store(false)
+	refresher.logger.Info().Dur("interval", refresher.interval).Dur("jitter", refresher.jitter).
+		Msg("WPRefresher: Background refresh started")
+
+	failures := 0
+	timer := time.NewTimer(refresher.nextDelay(failures))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			refresher.logger.Info().Msg("WPRefresher: Background refresh stopped")
+			return
+		case <-timer.C:
+			if _, err := refresher.storage.UpdateWallpostStorage(); err != nil {
+				failures++
+				refresher.logger.Warn().Err(err).Int("failures", failures).Msg("WPRefresher: Background refresh failed")
+			} else {
+				failures = 0
+				refresher.logger.Debug().Int("posts", refresher.storage.GetWallpostCount()).
+					Msg("WPRefresher: Storage refreshed")
+			}
+			timer.Reset(refresher.nextDelay(failures))
+		}
+	}
+}
+
+// nextDelay calculates the delay before the next update, given the number of consecutive failed updates.
+func (refresher *WallpostRefresher) nextDelay(failures int) time.Duration {
+	delay := refresher.interval
+	if failures > 0 {
+		maxBackoff := refresher.maxBackoff
+		if maxBackoff <= 0 {
+			maxBackoff = refresher.interval
+		}
+		delay = min(refresherInitialBackoff<<min(failures-1, 16), maxBackoff)
+	}
+	if refresher.jitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(refresher.jitter)))
+	}
+	return delay
+}
@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/SevereCloud/vksdk/v2/api"
+	"github.com/SevereCloud/vksdk/v2/object"
+	"github.com/alphatoasterous/otlozhka-bot/api_utils"
+	"github.com/alphatoasterous/otlozhka-bot/config"
+	"github.com/rs/zerolog"
+)
+
+// ReminderScheduler watches WallpostStorage and messages post authors some time before their posts get published.
+// Every reminder is recorded in a file once it's sent, so it is not sent again, even across restarts.
+// Reminders that failed to send are retried on every check until the post gets published,
+// unless the author can't be messaged at all.
+type ReminderScheduler struct {
+	configStore *config.Store
+	storage     *WallpostStorage
+	vkCommunity *api.VK
+	logger      zerolog.Logger
+
+	leadTimes     []time.Duration // sorted in ascending order
+	checkInterval time.Duration
+	sentPath      string
+
+	// sent maps keys of already sent reminders to publication dates of their posts.
+	// Dates are used to forget reminders for posts that have already been published.
+	sent map[string]int64
+}
+
+// NewReminderScheduler creates a ReminderScheduler, sending reminders via the `*api.VK` client with Community access,
+// formatted with the configuration currently held by `configStore`.
+// A reminder is sent `leadTimes` before post publication, storage is checked every `checkInterval`.
+// Sent reminders are recorded in `sentPath`; an empty path keeps them in memory only.
+func NewReminderScheduler(configStore *config.Store, storage *WallpostStorage, vkCommunity *api.VK,
+	leadTimes []time.Duration, checkInterval time.Duration, sentPath string, logger zerolog.Logger) *ReminderScheduler {
+	sortedLeadTimes := slices.Clone(leadTimes)
+	slices.Sort(sortedLeadTimes)
+	return &ReminderScheduler{
+		configStore:   configStore,
+		storage:       storage,
+		vkCommunity:   vkCommunity,
+		logger:        logger,
+		leadTimes:     sortedLeadTimes,
+		checkInterval: checkInterval,
+		sentPath:      sentPath,
+		sent:          make(map[string]int64),
+	}
+}
+
+// LoadSentReminders restores the list of sent reminders from file. A missing file is not an error.
+func (scheduler *ReminderScheduler) LoadSentReminders() error {
+	if scheduler.sentPath == "" {
+		return nil
+	}
+	data, err := os.ReadFile(scheduler.sentPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &scheduler.sent)
+}
+
+// saveSentReminders writes the list of sent reminders to file.
+func (scheduler *ReminderScheduler) saveSentReminders() error {
+	if scheduler.sentPath == "" {
+		return nil
+	}
+	data, err := json.Marshal(scheduler.sent)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(scheduler.sentPath, data)
+}
+
+// Run checks for due reminders until ctx is cancelled. It blocks, so it should be started in its own goroutine.
+func (scheduler *ReminderScheduler) Run(ctx context.Context) {
+	scheduler.logger.Info().Int("leadTimes", len(scheduler.leadTimes)).Dur("checkInterval", scheduler.checkInterval).
+		Msg("Reminders: Scheduler started")
+	ticker := time.NewTicker(scheduler.checkInterval)
+	defer ticker.Stop()
+
+	for {
+		scheduler.checkReminders(time.Now())
+		select {
+		case <-ctx.Done():
+			scheduler.logger.Info().Msg("Reminders: Scheduler stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// reminderKey identifies a reminder for a post publication. Publication date is a part of the key,
+// so a rescheduled post gets reminded of again.
+func reminderKey(post object.WallWallpost, leadTime time.Duration) string {
+	return fmt.Sprintf("%d_%d_%d_%d", post.OwnerID, post.ID, post.Date, int64(leadTime.Seconds()))
+}
+
+// checkReminders sends every reminder due at `now`. If several reminders of a post are due at once
+// (e.g. the bot was down, or a post was scheduled shortly before publication), only the latest one is sent.
+// Nothing is sent while reminders are disabled by a reloaded configuration, whose formats aren't validated then.
+func (scheduler *ReminderScheduler) checkReminders(now time.Time) {
+	cfg := scheduler.configStore.Current()
+	if !cfg.Reminders.Enabled {
+		scheduler.logger.Debug().Msg("Reminders: Disabled in configuration, skipping check")
+		return
+	}
+
+	type dueReminder struct {
+		post object.WallWallpost
+		keys []string
+	}
+	var due []dueReminder
+
+	for _, post := range scheduler.storage.GetWallposts() {
+		if post.SignerID <= 0 {
+			continue
+		}
+		publishedAt := time.Unix(int64(post.Date), 0)
+		if !now.Before(publishedAt) {
+			continue
+		}
+		var keys []string
+		for _, leadTime := range scheduler.leadTimes {
+			key := reminderKey(post, leadTime)
+			if _, sent := scheduler.sent[key]; !sent && !now.Before(publishedAt.Add(-leadTime)) {
+				keys = append(keys, key)
+			}
+		}
+		if len(keys) > 0 {
+			due = append(due, dueReminder{post: post, keys: keys})
+		}
+	}
+
+	// Forgetting reminders of posts that have already been published
+	changed := false
+	for key, date := range scheduler.sent {
+		if date <= now.Unix() {
+			delete(scheduler.sent, key)
+			changed = true
+		}
+	}
+
+	for _, reminder := range due {
+		scheduler.logger.Info().Int("signerID", reminder.post.SignerID).Int("postID", reminder.post.ID).
+			Int("date", reminder.post.Date).Msg("Reminders: Sending reminder")
+		msgs := api_utils.CreateMessageSendBuildersByUpcomingPost(cfg.MessageBuilder, cfg.Reminders.ReminderMsgFormat,
+			reminder.post)
+		err := sendAuthorNotification(scheduler.vkCommunity, reminder.post.SignerID, msgs)
+		switch {
+		case err == nil:
+		case isPermanentSendError(err):
+			scheduler.logger.Warn().Err(err).Int("signerID", reminder.post.SignerID).
+				Msg("Reminders: Author can't be messaged, dropping reminder")
+		default:
+			scheduler.logger.Warn().Err(err).Int("signerID", reminder.post.SignerID).
+				Msg("Reminders: Failed to send reminder, retrying on the next check")
+			continue
+		}
+		for _, key := range reminder.keys {
+			scheduler.sent[key] = int64(reminder.post.Date)
+		}
+		changed = true
+	}
+
+	if !changed {
+		return
+	}
+	if err := scheduler.saveSentReminders(); err != nil {
+		scheduler.logger.Error().Err(err).Str("path", scheduler.sentPath).
+			Msg("Reminders: Failed to save sent reminders, they may be sent again after a restart")
+	}
+}
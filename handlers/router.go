@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"regexp"
+
+	"github.com/SevereCloud/vksdk/v2/api"
+	"github.com/SevereCloud/vksdk/v2/object"
+	"github.com/alphatoasterous/otlozhka-bot/config"
+	"github.com/rs/zerolog"
+)
+
+// Role is the role a sender needs to run a command.
+type Role int
+
+const (
+	// RoleAuthor commands may be run by anyone
+	RoleAuthor Role = iota
+	// RoleManager commands may be run by community managers, or by anyone in a staff chat
+	RoleManager
+)
+
+// PeerType is a set of peer kinds a command may be run from.
+type PeerType int
+
+const (
+	PeerPrivate PeerType = 1 << iota // Private dialog with the community
+	PeerChat                         // Group chat
+	PeerAny     = PeerPrivate | PeerChat
+)
+
+// CommandContext holds an incoming message and everything a command handler needs to respond to it.
+type CommandContext struct {
+	Message object.MessagesMessage
+	// Configuration in effect when the message came, used for the whole handling of the message
+	Config *config.BotConfiguration
+	// Lowercase message text, as matched against command triggers
+	Text string
+	// Peer that command answers should be sent to
+	ReplyPeerID int
+	IsManager   bool
+	Rules       config.ChatConfiguration
+	// Whether the sender's VK client supports callback buttons in keyboards
+	CallbackButtons bool
+	// Payload of the keyboard button that ran the command, if any
+	Payload ButtonPayload
+	// Conversation message ID of the message whose callback button ran the command; 0 if there is none.
+	// Commands may edit this message instead of sending a new one.
+	EditableMessageID int
+
+	VKCommunity *api.VK
+	// Sender sends and edits answers, via VKCommunity unless replaced
+	Sender  MessageSender
+	Storage *WallpostStorage
+	// Router running the command
+	Router *Router
+	// Logger for the handling of the message
+	Logger zerolog.Logger
+}
+
+// MessageSender sends and edits messages on behalf of the community.
+// The `*api.VK` client with Community access implements it.
+type MessageSender interface {
+	MessagesSend(params api.Params) (int, error)
+	MessagesEdit(params api.Params) (int, error)
+}
+
+// Command describes a bot command: when it is triggered, who may run it, and what it does.
+type Command struct {
+	// Name identifies the command in chat rules and logs
+	Name string
+	// Triggers are regular expressions, any of which triggers the command when matched in a message
+	Triggers []*regexp.Regexp
+	Role     Role
+	// PeerTypes lists where the command may be run from
+	PeerTypes PeerType
+	// PersonalReply marks answers concerning the sender only, which chats may route to the sender privately
+	PersonalReply bool
+	Handler       func(ctx *CommandContext) error
+}
+
+// matches reports whether any of command triggers matches the text.
+func (command Command) matches(text string) bool {
+	for _, trigger := range command.Triggers {
+		if trigger != nil && trigger.MatchString(text) {
+			return true
+		}
+	}
+	return false
+}
+
+// isAllowed reports whether the command may be run in the given context.
+func (command Command) isAllowed(ctx *CommandContext) bool {
+	peerType := PeerPrivate
+	if isChat(ctx.Message.PeerID) {
+		peerType = PeerChat
+	}
+	if command.PeerTypes&peerType == 0 {
+		return false
+	}
+	return canRunCommand(ctx.Rules, command.Name, command.Role == RoleManager, ctx.IsManager)
+}
+
+// Router dispatches incoming messages to registered commands.
+type Router struct {
+	commands []Command
+	logger   zerolog.Logger
+}
+
+// NewRouter creates an empty Router, logging commands run to `logger`.
+func NewRouter(logger zerolog.Logger) *Router {
+	return &Router{logger: logger}
+}
+
+// Register adds a command to the router. Commands are tried in order of registration.
+func (router *Router) Register(command Command) {
+	router.commands = append(router.commands, command)
+}
+
+// Commands returns every registered command, in order of registration.
+func (router *Router) Commands() []Command {
+	return router.commands
+}
+
+// AvailableCommands returns registered commands which may be run in given context, in order of registration.
+func (router *Router) AvailableCommands(ctx *CommandContext) []Command {
+	var available []Command
+	for _, command := range router.commands {
+		if command.isAllowed(ctx) {
+			available = append(available, command)
+		}
+	}
+	return available
+}
+
+// Dispatch runs the first registered command, which is triggered by the message and may be run in given context.
+// Returns false if no command was run, or the error returned by the command handler.
+func (router *Router) Dispatch(ctx *CommandContext) (bool, error) {
+	for _, command := range router.commands {
+		if !command.matches(ctx.Text) || !command.isAllowed(ctx) {
+			continue
+		}
+		return true, router.run(command, ctx)
+	}
+	return false, nil
+}
+
+// DispatchCommand runs the command with the given name, if it may be run in given context.
+// Used for keyboard buttons, which name the command they run instead of triggering it with text.
+// Returns false if no command was run, or the error returned by the command handler.
+func (router *Router) DispatchCommand(name string, ctx *CommandContext) (bool, error) {
+	for _, command := range router.commands {
+		if command.Name != name || !command.isAllowed(ctx) {
+			continue
+		}
+		return true, router.run(command, ctx)
+	}
+	router.logger.Warn().Str("command", name).Int("peerID", ctx.Message.PeerID).Int("fromID", ctx.Message.FromID).
+		Msg("Command is unknown or not allowed")
+	return false, nil
+}
+
+// run runs a command, routing its answers according to peer rules.
+func (router *Router) run(command Command, ctx *CommandContext) error {
+	ctx.Router = router
+	ctx.ReplyPeerID = ctx.Message.PeerID
+	if command.PersonalReply && ctx.Rules.ReplyPrivately {
+		ctx.ReplyPeerID = ctx.Message.FromID
+	}
+	router.logger.Info().Str("command", command.Name).Int("peerID", ctx.Message.PeerID).
+		Int("fromID", ctx.Message.FromID).Bool("isManager", ctx.IsManager).Str("text", ctx.Message.Text).
+		Msg("Running command")
+	return command.Handler(ctx)
+}
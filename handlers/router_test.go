@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"regexp"
+	"slices"
+	"testing"
+
+	"github.com/SevereCloud/vksdk/v2/api"
+	"github.com/SevereCloud/vksdk/v2/object"
+	"github.com/alphatoasterous/otlozhka-bot/config"
+	"github.com/rs/zerolog"
+)
+
+// sentMessage is a message sent through fakeSender.
+type sentMessage struct {
+	PeerID int
+	Text   string
+}
+
+// fakeSender records messages instead of sending them.
+type fakeSender struct {
+	sent []sentMessage
+}
+
+func (sender *fakeSender) MessagesSend(params api.Params) (int, error) {
+	peerID, _ := params["peer_id"].(int)
+	text, _ := params["message"].(string)
+	sender.sent = append(sender.sent, sentMessage{PeerID: peerID, Text: text})
+	return len(sender.sent), nil
+}
+
+func (sender *fakeSender) MessagesEdit(params api.Params) (int, error) {
+	return sender.MessagesSend(params)
+}
+
+const (
+	testChatPeerID  = chatPeerIDOffset + 1
+	testStaffPeerID = chatPeerIDOffset + 2
+	testOtherPeerID = chatPeerIDOffset + 3
+	testAuthorID    = 101
+	testManagerID   = 102
+)
+
+// testChats allows a couple of commands in an ordinary chat replying privately, and every command in a staff chat.
+var testChats = []config.ChatConfiguration{
+	{PeerID: testChatPeerID, AllowedCommands: []string{CommandOtlozhka, CommandUpdateStorage}, ReplyPrivately: true},
+	{PeerID: testStaffPeerID, AllowedCommands: config.CommandNames, Staff: true},
+}
+
+// newTestRouter registers commands covering every role and peer type. Each command replies with its own name.
+func newTestRouter() *Router {
+	router := NewRouter(zerolog.Nop())
+	register := func(name, trigger string, role Role, peerTypes PeerType, personalReply bool) {
+		router.Register(Command{
+			Name:          name,
+			Triggers:      []*regexp.Regexp{regexp.MustCompile(trigger)},
+			Role:          role,
+			PeerTypes:     peerTypes,
+			PersonalReply: personalReply,
+			Handler: func(ctx *CommandContext) error {
+				return ctx.Reply(name)
+			},
+		})
+	}
+	register(CommandOtlozhka, "^отложка", RoleAuthor, PeerAny, true)
+	register(CommandUpdateStorage, "^обновить", RoleManager, PeerAny, false)
+	register(CommandExport, "^экспорт", RoleAuthor, PeerPrivate, false)
+	register(CommandSchedule, "^расписание", RoleManager, PeerChat, false)
+	return router
+}
+
+// newTestContext builds a context for a message sent from `fromID` to `peerID`, answered through the returned sender.
+func newTestContext(peerID, fromID int, text string) (*CommandContext, *fakeSender) {
+	cfg := config.DefaultBotConfiguration()
+	cfg.Chats = testChats
+	sender := &fakeSender{}
+	return &CommandContext{
+		Message:     object.MessagesMessage{PeerID: peerID, FromID: fromID, Text: text},
+		Config:      &cfg,
+		Text:        text,
+		ReplyPeerID: peerID,
+		IsManager:   fromID == testManagerID,
+		Rules:       getPeerRules(peerID, cfg.Chats),
+		Sender:      sender,
+		Logger:      zerolog.Nop(),
+	}, sender
+}
+
+func TestRouterDispatch(t *testing.T) {
+	tests := []struct {
+		name        string
+		peerID      int
+		fromID      int
+		text        string
+		wantCommand string // Empty if no command should run
+		wantPeerID  int
+	}{
+		{"author command in private", testAuthorID, testAuthorID, "отложка", CommandOtlozhka, testAuthorID},
+		{"manager command by author", testAuthorID, testAuthorID, "обновить", "", 0},
+		{"manager command by manager", testManagerID, testManagerID, "обновить", CommandUpdateStorage, testManagerID},
+		{"private command in private", testAuthorID, testAuthorID, "экспорт", CommandExport, testAuthorID},
+		{"chat command in private", testManagerID, testManagerID, "расписание", "", 0},
+		{"private command in chat", testStaffPeerID, testAuthorID, "экспорт", "", 0},
+		{"chat command in staff chat", testStaffPeerID, testAuthorID, "расписание", CommandSchedule, testStaffPeerID},
+		{"manager command in staff chat", testStaffPeerID, testAuthorID, "обновить", CommandUpdateStorage,
+			testStaffPeerID},
+		{"manager command by author in chat", testChatPeerID, testAuthorID, "обновить", "", 0},
+		{"manager command by manager in chat", testChatPeerID, testManagerID, "обновить", CommandUpdateStorage,
+			testChatPeerID},
+		{"personal reply in chat", testChatPeerID, testAuthorID, "отложка", CommandOtlozhka, testAuthorID},
+		{"command not allowed in chat", testChatPeerID, testManagerID, "расписание", "", 0},
+		{"allowed command in unconfigured chat", testOtherPeerID, testAuthorID, "отложка", CommandOtlozhka,
+			testOtherPeerID},
+		{"command not allowed in unconfigured chat", testOtherPeerID, testManagerID, "обновить", "", 0},
+		{"no trigger", testAuthorID, testAuthorID, "привет", "", 0},
+	}
+	router := newTestRouter()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, sender := newTestContext(test.peerID, test.fromID, test.text)
+			ran, err := router.Dispatch(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ran != (test.wantCommand != "") {
+				t.Fatalf("Dispatch ran a command: %v, want %v", ran, test.wantCommand != "")
+			}
+			var want []sentMessage
+			if test.wantCommand != "" {
+				want = []sentMessage{{PeerID: test.wantPeerID, Text: test.wantCommand}}
+			}
+			if !slices.Equal(sender.sent, want) {
+				t.Errorf("sent %v, want %v", sender.sent, want)
+			}
+		})
+	}
+}
+
+func TestRouterDispatchCommand(t *testing.T) {
+	router := newTestRouter()
+
+	// Buttons name the command, so a button of a command not allowed here doesn't run it
+	ctx, sender := newTestContext(testChatPeerID, testAuthorID, "")
+	if ran, err := router.DispatchCommand(CommandUpdateStorage, ctx); ran || err != nil {
+		t.Errorf("DispatchCommand(%q) = %v, %v, want false, nil", CommandUpdateStorage, ran, err)
+	}
+	if ran, err := router.DispatchCommand("unknown", ctx); ran || err != nil {
+		t.Errorf("DispatchCommand(%q) = %v, %v, want false, nil", "unknown", ran, err)
+	}
+	if ran, err := router.DispatchCommand(CommandOtlozhka, ctx); !ran || err != nil {
+		t.Errorf("DispatchCommand(%q) = %v, %v, want true, nil", CommandOtlozhka, ran, err)
+	}
+	want := []sentMessage{{PeerID: testAuthorID, Text: CommandOtlozhka}}
+	if !slices.Equal(sender.sent, want) {
+		t.Errorf("sent %v, want %v", sender.sent, want)
+	}
+}
+
+func TestRouterAvailableCommands(t *testing.T) {
+	tests := []struct {
+		name   string
+		peerID int
+		fromID int
+		want   []string
+	}{
+		{"author in private", testAuthorID, testAuthorID, []string{CommandOtlozhka, CommandExport}},
+		{"manager in private", testManagerID, testManagerID,
+			[]string{CommandOtlozhka, CommandUpdateStorage, CommandExport}},
+		{"author in chat", testChatPeerID, testAuthorID, []string{CommandOtlozhka}},
+		{"manager in chat", testChatPeerID, testManagerID, []string{CommandOtlozhka, CommandUpdateStorage}},
+		{"author in staff chat", testStaffPeerID, testAuthorID,
+			[]string{CommandOtlozhka, CommandUpdateStorage, CommandSchedule}},
+		{"manager in unconfigured chat", testOtherPeerID, testManagerID, []string{CommandOtlozhka}},
+	}
+	router := newTestRouter()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, _ := newTestContext(test.peerID, test.fromID, "")
+			var got []string
+			for _, command := range router.AvailableCommands(ctx) {
+				got = append(got, command.Name)
+			}
+			if !slices.Equal(got, test.want) {
+				t.Errorf("available commands %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCommandRouterNames(t *testing.T) {
+	var names []string
+	for _, command := range newCommandRouter(config.CompiledRegexes{}, zerolog.Nop()).Commands() {
+		names = append(names, command.Name)
+	}
+	slices.Sort(names)
+	want := slices.Clone(config.CommandNames)
+	slices.Sort(want)
+	if !slices.Equal(names, want) {
+		t.Errorf("router commands %v, want the configurable command names %v", names, want)
+	}
+}
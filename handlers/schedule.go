@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/SevereCloud/vksdk/v2/api"
+	"github.com/alphatoasterous/otlozhka-bot/api_utils"
+	"github.com/alphatoasterous/otlozhka-bot/config"
+	"github.com/rs/zerolog"
+)
+
+// checkSchedule analyses stored posts for collisions, empty days and gaps, as configured,
+// and formats the result. Returns the report along with its text.
+func checkSchedule(cfg *config.BotConfiguration, storage *WallpostStorage,
+	now time.Time) (api_utils.ScheduleReport, string, error) {
+	schedule := cfg.Schedule
+	loc, err := time.LoadLocation(cfg.MessageBuilder.Timezone)
+	if err != nil {
+		return api_utils.ScheduleReport{}, "", fmt.Errorf("loading timezone: %w", err)
+	}
+	report := api_utils.AnalyzeSchedule(storage.GetWallposts(), now, loc,
+		time.Duration(schedule.CollisionWindow)*time.Minute, time.Duration(schedule.MaxGap)*time.Hour,
+		schedule.LookaheadDays)
+	text, err := api_utils.GetFormattedScheduleReport(report, loc, schedule)
+	if err != nil {
+		return report, "", fmt.Errorf("formatting schedule report: %w", err)
+	}
+	return report, text, nil
+}
+
+// handleScheduleCheck sends a manager the list of schedule problems: colliding posts, empty days and long gaps.
+func handleScheduleCheck(ctx *CommandContext) error {
+	updateStorageIfStale(ctx.Storage)
+	_, text, err := checkSchedule(ctx.Config, ctx.Storage, time.Now())
+	if err != nil {
+		return err
+	}
+	return ctx.Reply(text)
+}
+
+// ScheduleReporter sends the schedule check result to configured peers daily.
+type ScheduleReporter struct {
+	configStore *config.Store
+	storage     *WallpostStorage
+	vkCommunity *api.VK
+	logger      zerolog.Logger
+
+	// Report time, as hours and minutes since midnight
+	reportAt time.Duration
+	peerIDs  []int
+}
+
+// NewScheduleReporter creates a ScheduleReporter, sending reports via the `*api.VK` client with Community access
+// at `reportTime` ("HH:MM" in the configured timezone) to `peerIDs`.
+// The storage gets updated before every report, if it is stale.
+// Reports are built with the configuration currently held by `configStore`.
+func NewScheduleReporter(configStore *config.Store, storage *WallpostStorage, vkCommunity *api.VK,
+	reportTime string, peerIDs []int, logger zerolog.Logger) (*ScheduleReporter, error) {
+	reportAt, err := parseClock(reportTime)
+	if err != nil {
+		return nil, fmt.Errorf("parsing report time: %w", err)
+	}
+	return &ScheduleReporter{
+		configStore: configStore,
+		storage:     storage,
+		vkCommunity: vkCommunity,
+		logger:      logger,
+		reportAt:    reportAt,
+		peerIDs:     peerIDs,
+	}, nil
+}
+
+// nextReportTime returns the earliest report time after `now`.
+func (reporter *ScheduleReporter) nextReportTime(now time.Time) time.Time {
+	loc, err := time.LoadLocation(reporter.configStore.Current().MessageBuilder.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	now = now.In(loc)
+	next := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc).Add(reporter.reportAt)
+	if !next.After(now) {
+		next = time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, loc).Add(reporter.reportAt)
+	}
+	return next
+}
+
+// Run sends a report every day until ctx is cancelled. It blocks, so it should be started in its own goroutine.
+func (reporter *ScheduleReporter) Run(ctx context.Context) {
+	reporter.logger.Info().Dur("reportAt", reporter.reportAt).Ints("peerIDs", reporter.peerIDs).
+		Msg("Schedule report: Reporter started")
+	for {
+		next := reporter.nextReportTime(time.Now())
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			reporter.logger.Info().Msg("Schedule report: Reporter stopped")
+			return
+		case <-timer.C:
+		}
+		reporter.sendReport()
+	}
+}
+
+// sendReport sends the schedule check result to every configured peer. Nothing is sent if no problems are found.
+func (reporter *ScheduleReporter) sendReport() {
+	updateStorageIfStale(reporter.storage)
+	report, text, err := checkSchedule(reporter.configStore.Current(), reporter.storage, time.Now())
+	if err != nil {
+		reporter.logger.Error().Err(err).Msg("Schedule report: Failed to check schedule")
+		return
+	}
+	if report.IsEmpty() {
+		reporter.logger.Info().Msg("Schedule report: No problems found, skipping report")
+		return
+	}
+	for _, peerID := range reporter.peerIDs {
+		if err := sendText(reporter.vkCommunity, peerID, text, nil); err != nil {
+			reporter.logger.Warn().Err(err).Int("peerID", peerID).Msg("Schedule report: Failed to send report")
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/alphatoasterous/otlozhka-bot/api_utils"
+	"github.com/alphatoasterous/otlozhka-bot/config"
+)
+
+// parseClock parses a time of day in "HH:MM" format into time since midnight.
+func parseClock(clock string) (time.Duration, error) {
+	parsed, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(parsed.Hour())*time.Hour + time.Duration(parsed.Minute())*time.Minute, nil
+}
+
+// getFormattedFreeSlots finds the earliest free slots of the configured publishing grid and formats them.
+func getFormattedFreeSlots(cfg *config.BotConfiguration, storage *WallpostStorage, now time.Time) (string, error) {
+	slots := cfg.Slots
+	loc, err := time.LoadLocation(cfg.MessageBuilder.Timezone)
+	if err != nil {
+		return "", fmt.Errorf("loading timezone: %w", err)
+	}
+	gridStart, err := parseClock(slots.GridStart)
+	if err != nil {
+		return "", fmt.Errorf("parsing slot grid start: %w", err)
+	}
+	gridEnd, err := parseClock(slots.GridEnd)
+	if err != nil {
+		return "", fmt.Errorf("parsing slot grid end: %w", err)
+	}
+	grid := api_utils.SlotGrid{
+		Start:       gridStart,
+		End:         gridEnd,
+		Interval:    time.Duration(slots.GridInterval) * time.Minute,
+		TakenWindow: time.Duration(cfg.Schedule.CollisionWindow) * time.Minute,
+	}
+	freeSlots := api_utils.GetFreeSlots(storage.GetWallposts(), now, loc, grid, slots.SuggestCount, slots.LookaheadDays)
+	return api_utils.GetFormattedFreeSlots(freeSlots, loc, slots), nil
+}
+
+// handleFreeSlots sends the sender the earliest free publication slots.
+func handleFreeSlots(ctx *CommandContext) error {
+	updateStorageIfStale(ctx.Storage)
+	text, err := getFormattedFreeSlots(ctx.Config, ctx.Storage, time.Now())
+	if err != nil {
+		return err
+	}
+	return ctx.Reply(text)
+}
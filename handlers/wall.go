@@ -1,71 +1,370 @@
 package handlers
 
 import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/SevereCloud/vksdk/v2/api"
 	"github.com/SevereCloud/vksdk/v2/object"
-	"github.com/alphatoasterous/otlozhka-bot/logging"
+	"github.com/rs/zerolog"
 )
 
 // WallpostStorage manages the storage and retrieval of wall posts.
 // It holds a collection of wall posts and timestamps to manage data freshness.
 // Storing wallposts in memory reduces VK API calls and does not affect user experience, which is optimal.
+// If a snapshot path is set, every update is also saved to disk, so the storage survives restarts.
+// WallpostStorage is safe for concurrent use: readers always get a consistent snapshot of stored posts,
+// and concurrent updates are merged into a single in-flight fetch.
 type WallpostStorage struct {
-	timestamp int64
-	keepAlive int64
+	fetcher      WallpostFetcher
+	keepAlive    int64
+	snapshotPath string
+	logger       zerolog.Logger
 
+	mu        sync.RWMutex // guards timestamp and wallPosts
+	timestamp int64
 	wallPosts []object.WallWallpost
+
+	updateMu sync.Mutex // guards update
+	update   *wallpostUpdate
+
+	listenersMu sync.Mutex // guards listeners
+	listeners   []func(diff WallpostDiff)
+	diffs       chan queuedDiff // diffs waiting for listeners, in order of updates
+	notifier    sync.Once       // starts the goroutine calling listeners
+
+	snapshotMu sync.Mutex // serializes snapshot file writes
+
+	// Set while a WallpostRefresher keeps the storage up to date, so requests don't update it themselves
+	refreshedInBackground atomic.Bool
+}
+
+// wallpostDiffQueueSize is the number of diffs queued for listeners before updates wait for them.
+const wallpostDiffQueueSize = 64
+
+// queuedDiff is a diff queued for listeners. A flush marker has no diff and is closed once every diff
+// queued before it has been handled.
+type queuedDiff struct {
+	diff    WallpostDiff
+	flushed chan struct{}
+}
+
+// wallpostUpdate represents an in-flight storage update, shared by every caller waiting for it.
+type wallpostUpdate struct {
+	done chan struct{}
+	diff WallpostDiff
+	err  error
 }
 
 // NewWallpostStorage initializes a new WallpostStorage with a specified keepAlive duration.
+// The fetcher parameter provides postponed posts the storage gets updated with.
 // The keepAlive parameter determines how long (in seconds) the posts are considered fresh.
+// The snapshotPath parameter sets a file used by LoadSnapshot and SaveSnapshot; an empty path disables persistence.
 // Returns a pointer to the newly created WallpostStorage.
-func NewWallpostStorage(keepAlive int64) *WallpostStorage {
+func NewWallpostStorage(fetcher WallpostFetcher, keepAlive int64, snapshotPath string,
+	logger zerolog.Logger) *WallpostStorage {
 	return &WallpostStorage{
-		timestamp: 0,
-		keepAlive: keepAlive,
+		fetcher:      fetcher,
+		timestamp:    0,
+		keepAlive:    keepAlive,
+		snapshotPath: snapshotPath,
+		logger:       logger,
+		diffs:        make(chan queuedDiff, wallpostDiffQueueSize),
 	}
 }
 
 // GetWallposts retrieves all the wall posts currently stored in WallpostStorage.
 // It logs the retrieval process and the number of posts fetched.
-// Returns a slice of WallWallpost objects.
+// Returns a slice of WallWallpost objects. The returned slice is shared and must not be modified.
 func (wpStorage *WallpostStorage) GetWallposts() []object.WallWallpost {
-	logging.Log.Print("WPStorage: Getting Wallposts from storage... Stored posts amount:", wpStorage.GetWallpostCount())
-	return wpStorage.wallPosts
+	wpStorage.mu.RLock()
+	wallPosts := wpStorage.wallPosts
+	wpStorage.mu.RUnlock()
+	wpStorage.logger.Print("WPStorage: Getting Wallposts from storage... Stored posts amount:", len(wallPosts))
+	return wallPosts
 }
 
 // GetWallpostCount returns the number of wall posts currently stored.
 func (wpStorage *WallpostStorage) GetWallpostCount() int {
+	wpStorage.mu.RLock()
+	defer wpStorage.mu.RUnlock()
 	return len(wpStorage.wallPosts)
 }
 
+// isFilled reports whether the storage has ever been filled, by an update or from a snapshot.
+func (wpStorage *WallpostStorage) isFilled() bool {
+	wpStorage.mu.RLock()
+	defer wpStorage.mu.RUnlock()
+	return wpStorage.timestamp != 0
+}
+
 // CheckWallpostStorageNeedsUpdate checks if the wall posts in the storage are stale based on the keepAlive setting.
 // Logs a message indicating whether the posts are stale or not.
 // Returns true if the posts are stale and need an update; false otherwise.
 func (wpStorage *WallpostStorage) CheckWallpostStorageNeedsUpdate() bool {
+	wpStorage.mu.RLock()
+	timestamp := wpStorage.timestamp
+	wpStorage.mu.RUnlock()
+
 	currentTimestamp := time.Now().Unix()
-	if currentTimestamp-wpStorage.timestamp >= wpStorage.keepAlive {
-		logging.Log.Info().Msg("WPStorage: Wallposts in wallpost storage are stale")
+	if currentTimestamp-timestamp >= wpStorage.keepAlive {
+		wpStorage.logger.Info().Msg("WPStorage: Wallposts in wallpost storage are stale")
 		return true
 	} else {
-		logging.Log.Info().Msg("WPStorage: Wallposts in wallpost storage are not stale")
+		wpStorage.logger.Info().Msg("WPStorage: Wallposts in wallpost storage are not stale")
+		return false
+	}
+}
+
+// OnUpdate registers a listener, called with a diff after every update that changed stored posts.
+// Listeners are called one at a time on a goroutine of their own, in the order updates happened,
+// so updates don't wait for them. No diff is produced for the very first update of an empty storage,
+// since every post would be reported as added.
+func (wpStorage *WallpostStorage) OnUpdate(listener func(diff WallpostDiff)) {
+	wpStorage.listenersMu.Lock()
+	wpStorage.listeners = append(wpStorage.listeners, listener)
+	wpStorage.listenersMu.Unlock()
+	wpStorage.notifier.Do(func() { go wpStorage.notifyListeners() })
+}
+
+// WaitForListeners waits up to `timeout` for listeners to handle every diff queued so far.
+// Returns false if some diffs were still queued when the timeout expired.
+func (wpStorage *WallpostStorage) WaitForListeners(timeout time.Duration) bool {
+	wpStorage.listenersMu.Lock()
+	hasListeners := len(wpStorage.listeners) > 0
+	wpStorage.listenersMu.Unlock()
+	if !hasListeners {
+		return true
+	}
+	expired := time.After(timeout)
+	flush := queuedDiff{flushed: make(chan struct{})}
+	select {
+	case wpStorage.diffs <- flush:
+	case <-expired:
+		return false
+	}
+	select {
+	case <-flush.flushed:
+		return true
+	case <-expired:
 		return false
 	}
 }
 
-// UpdateWallpostStorage fetches and updates the wall posts from a specified VK domain.
-// It calls GetAllPostponedWallposts to retrieve new data, logs critical errors, and updates the internal timestamp.
-func (wpStorage *WallpostStorage) UpdateWallpostStorage(vkUser *api.VK, domain string) {
+// notifyListeners passes queued diffs to listeners, one at a time. It runs for the lifetime of the storage.
+func (wpStorage *WallpostStorage) notifyListeners() {
+	for queued := range wpStorage.diffs {
+		if queued.flushed != nil {
+			close(queued.flushed)
+			continue
+		}
+		wpStorage.listenersMu.Lock()
+		listeners := slices.Clone(wpStorage.listeners)
+		wpStorage.listenersMu.Unlock()
+		for _, listener := range listeners {
+			listener(queued.diff)
+		}
+	}
+}
+
+// queueDiff queues a diff for listeners, if there are any. It only waits if the queue is full.
+func (wpStorage *WallpostStorage) queueDiff(diff WallpostDiff) {
+	wpStorage.listenersMu.Lock()
+	hasListeners := len(wpStorage.listeners) > 0
+	wpStorage.listenersMu.Unlock()
+	if !hasListeners {
+		return
+	}
+	wpStorage.diffs <- queuedDiff{diff: diff}
+}
+
+// UpdateWallpostStorage fetches and updates the wall posts with the storage fetcher.
+// It retrieves postponed posts and updates the internal timestamp.
+// If an update is already in progress, UpdateWallpostStorage waits for it and returns its result
+// instead of starting another fetch.
+// Updated storage is saved as a snapshot; failing to save it is logged, but does not affect stored posts.
+// Returns the diff between previous and updated posts, or an error if fetching posts failed,
+// in which case stored posts are left untouched.
+func (wpStorage *WallpostStorage) UpdateWallpostStorage() (WallpostDiff, error) {
+	return wpStorage.runUpdate(false)
+}
+
+// UpdateWallpostStorageIfStale updates the storage the same way as UpdateWallpostStorage, unless stored posts are fresh.
+// Staleness is checked once more when no update is in flight, so callers that found the storage stale
+// at the same time share a single fetch, instead of fetching again right after the first one.
+// If an update is in flight and the storage has been filled, e.g. from a snapshot, it doesn't wait for the update,
+// so stored posts are served meanwhile.
+// Returns an empty diff if the storage was fresh or if the update was left to the in-flight one.
+func (wpStorage *WallpostStorage) UpdateWallpostStorageIfStale() (WallpostDiff, error) {
+	return wpStorage.runUpdate(true)
+}
+
+// runUpdate starts an update or waits for the in-flight one. If `onlyIfStale` is set, no update is started
+// when stored posts are fresh, and the in-flight update is only waited for when the storage is empty.
+func (wpStorage *WallpostStorage) runUpdate(onlyIfStale bool) (WallpostDiff, error) {
+	wpStorage.updateMu.Lock()
+	if update := wpStorage.update; update != nil {
+		wpStorage.updateMu.Unlock()
+		if onlyIfStale && wpStorage.isFilled() {
+			wpStorage.logger.Debug().Msg("WPStorage: Update is in flight, serving stored posts meanwhile")
+			return WallpostDiff{}, nil
+		}
+		wpStorage.logger.Debug().Msg("WPStorage: Waiting for in-flight update")
+		<-update.done
+		return update.diff, update.err
+	}
+	// The timestamp is set before an update is cleared, so a fresh timestamp here means no update is needed
+	if onlyIfStale && !wpStorage.CheckWallpostStorageNeedsUpdate() {
+		wpStorage.updateMu.Unlock()
+		return WallpostDiff{}, nil
+	}
+	update := &wallpostUpdate{done: make(chan struct{})}
+	wpStorage.update = update
+	wpStorage.updateMu.Unlock()
 
-	postponedPosts, err := GetAllPostponedWallposts(vkUser, domain)
+	var hadPosts bool
+	update.diff, hadPosts, update.err = wpStorage.fetchWallposts()
+
+	// The diff is queued before the next update may start, so diffs reach listeners in order
+	if update.err == nil && hadPosts && !update.diff.IsEmpty() {
+		update.diff.Log(wpStorage.logger)
+		wpStorage.queueDiff(update.diff)
+	}
+
+	wpStorage.updateMu.Lock()
+	wpStorage.update = nil
+	wpStorage.updateMu.Unlock()
+	close(update.done)
+
+	return update.diff, update.err
+}
+
+// fetchWallposts does the actual work of UpdateWallpostStorage and must only be called by it.
+// Returns the diff against previously stored posts and whether the storage had been filled before.
+func (wpStorage *WallpostStorage) fetchWallposts() (WallpostDiff, bool, error) {
+	postponedPosts, err := wpStorage.fetcher.FetchPostponedWallposts()
 	if err != nil {
-		logging.Log.Fatal().Err(err)
+		return WallpostDiff{}, false, err
 	}
+
+	// Stored posts are only replaced by updates, which never run concurrently
+	wpStorage.mu.RLock()
+	hadPosts := wpStorage.timestamp != 0
+	previousPosts := wpStorage.wallPosts
+	wpStorage.mu.RUnlock()
+
+	now := time.Now().Unix()
+	postponedPosts, diff := wpStorage.confirmDeletedWallposts(previousPosts, postponedPosts,
+		DiffWallposts(previousPosts, postponedPosts, now), now)
+
+	wpStorage.mu.Lock()
 	wpStorage.wallPosts = postponedPosts
-	wpStorage.timestamp = time.Now().Unix()
+	wpStorage.timestamp = now
+	wpStorage.mu.Unlock()
 
+	if err := wpStorage.SaveSnapshot(); err != nil {
+		wpStorage.logger.Error().Err(err).Str("path", wpStorage.snapshotPath).Msg("WPStorage: Failed to save snapshot")
+	}
+	return diff, hadPosts, nil
+}
+
+// confirmDeletedWallposts checks posts the diff reports as deleted by fetching them by ID,
+// since a post also goes missing from postponed posts when it's published early with "publish now",
+// or when it's skipped while paging through postponed posts.
+// Posts still postponed are put back into `current`, and published ones are moved to Published.
+// If posts can't be fetched, they're put back as well, to be checked again on the next update.
+// Returns the corrected posts and diff.
+func (wpStorage *WallpostStorage) confirmDeletedWallposts(previous, current []object.WallWallpost, diff WallpostDiff,
+	now int64) ([]object.WallWallpost, WallpostDiff) {
+	if len(diff.Deleted) == 0 {
+		return current, diff
+	}
+	found, err := wpStorage.fetcher.FetchWallpostsByID(diff.Deleted)
+	if err != nil {
+		wpStorage.logger.Warn().Err(err).Strs("posts", wallpostLinks(diff.Deleted)).
+			Msg("WPStorage: Failed to confirm deleted posts, keeping them until the next update")
+		current = append(slices.Clip(current), diff.Deleted...)
+		return current, DiffWallposts(previous, current, now)
+	}
+
+	foundByKey := make(map[wallpostKey]object.WallWallpost, len(found))
+	for _, post := range found {
+		foundByKey[wallpostKey{post.OwnerID, post.ID}] = post
+	}
+	var stillPostponed []object.WallWallpost
+	for _, post := range diff.Deleted {
+		if foundPost, ok := foundByKey[wallpostKey{post.OwnerID, post.ID}]; ok && foundPost.PostType == "postpone" {
+			stillPostponed = append(stillPostponed, foundPost)
+		}
+	}
+	if len(stillPostponed) > 0 {
+		wpStorage.logger.Info().Strs("posts", wallpostLinks(stillPostponed)).
+			Msg("WPStorage: Posts missing from postponed posts are still postponed")
+		current = append(slices.Clip(current), stillPostponed...)
+		diff = DiffWallposts(previous, current, now)
+	}
+
+	var deleted []object.WallWallpost
+	for _, post := range diff.Deleted {
+		if _, ok := foundByKey[wallpostKey{post.OwnerID, post.ID}]; ok {
+			diff.Published = append(diff.Published, post)
+		} else {
+			deleted = append(deleted, post)
+		}
+	}
+	diff.Deleted = deleted
+	return current, diff
+}
+
+// WallpostFetcher fetches postponed posts WallpostStorage is filled with.
+type WallpostFetcher interface {
+	// FetchPostponedWallposts returns every postponed post of the community.
+	FetchPostponedWallposts() ([]object.WallWallpost, error)
+	// FetchWallpostsByID returns current versions of given posts, whether postponed or published.
+	// Deleted posts are left out.
+	FetchWallpostsByID(posts []object.WallWallpost) ([]object.WallWallpost, error)
+}
+
+// VKWallpostFetcher fetches postponed posts of a community from VK, via the `*api.VK` client with User access.
+type VKWallpostFetcher struct {
+	vkUser *api.VK
+	domain string
+	logger zerolog.Logger
+}
+
+// NewVKWallpostFetcher creates a VKWallpostFetcher for the community with a given `domain`.
+func NewVKWallpostFetcher(vkUser *api.VK, domain string, logger zerolog.Logger) *VKWallpostFetcher {
+	return &VKWallpostFetcher{vkUser: vkUser, domain: domain, logger: logger}
+}
+
+// FetchPostponedWallposts returns every postponed post of the community, fetched with GetAllPostponedWallposts.
+func (fetcher *VKWallpostFetcher) FetchPostponedWallposts() ([]object.WallWallpost, error) {
+	return GetAllPostponedWallposts(fetcher.vkUser, fetcher.domain, fetcher.logger)
+}
+
+// FetchWallpostsByID returns current versions of given posts, fetched with the `wall.getById` method
+// in batches of up to 100 posts. For more information about the method, check https://dev.vk.com/method/wall.getById
+func (fetcher *VKWallpostFetcher) FetchWallpostsByID(posts []object.WallWallpost) ([]object.WallWallpost, error) {
+	const maxPostsPerRequest = 100
+
+	var found []object.WallWallpost
+	for start := 0; start < len(posts); start += maxPostsPerRequest {
+		batch := posts[start:min(start+maxPostsPerRequest, len(posts))]
+		postIDs := make([]string, 0, len(batch))
+		for _, post := range batch {
+			postIDs = append(postIDs, fmt.Sprintf("%d_%d", post.OwnerID, post.ID))
+		}
+		response, err := fetcher.vkUser.WallGetByID(api.Params{"posts": strings.Join(postIDs, ",")})
+		if err != nil {
+			return nil, fmt.Errorf("fetching posts by ID: %w", err)
+		}
+		found = append(found, response...)
+	}
+	return found, nil
 }
 
 // flattenWallpostArray takes a two-dimensional slice of WallWallpost objects and flattens it into a single slice.
@@ -94,28 +393,30 @@ func flattenWallpostArray(posts [][]object.WallWallpost) []object.WallWallpost {
 // This method filters for "postponed" posts using the 'filter' field in the API request parameters.
 // On successful retrieval of all posts, the function returns a flat slice of WallWallpost objects.
 // If an error occurs during the API calls, it tries to retry five times, while logging the failure.
-// If retries fails, it crashes miserably.
+// If retries fail, the last error is returned.
 // The return includes a slice of all postponed WallWallpost objects and an error, if any occurred.
-func GetAllPostponedWallposts(vkUser *api.VK, domain string) ([]object.WallWallpost, error) {
+func GetAllPostponedWallposts(vkUser *api.VK, domain string, logger zerolog.Logger) ([]object.WallWallpost, error) {
 	const maxWallPostCount = 100
 	const maxRetries = 5
 	const retrySleepTime = time.Second * 2
 
-	var (
-		allPosts [][]object.WallWallpost
-		offset   int
-	)
-
 	// Retry function with panic recovery and a retry limit
 	// This was done due to possibility of post count being non-constant value.
 	// (e.g. postponed post got deleted/published while executing this function)
-	tryFetchingWallposts := func() ([]object.WallWallpost, error) {
+	// Every attempt starts over from the first page, since posts shift between pages once the count changes.
+	tryFetchingWallposts := func() (posts []object.WallWallpost, err error) {
 		defer func() {
 			if r := recover(); r != nil {
-				logging.Log.Warn().Msg("Recovered from panic, retrying...")
+				logger.Warn().Msg("Recovered from panic, retrying...")
+				posts, err = nil, fmt.Errorf("recovered from panic: %v", r)
 			}
 		}()
 
+		var (
+			allPosts   [][]object.WallWallpost
+			offset     int
+			firstCount int
+		)
 		for {
 			response, err := vkUser.WallGet(api.Params{
 				"domain": domain,
@@ -124,9 +425,15 @@ func GetAllPostponedWallposts(vkUser *api.VK, domain string) ([]object.WallWallp
 				"count":  maxWallPostCount,
 			})
 			if err != nil {
-				logging.Log.Warn().Err(err).Msg("Failed to fetch wall posts")
+				logger.Warn().Err(err).Msg("Failed to fetch wall posts")
 				return nil, err
 			}
+			if offset == 0 {
+				firstCount = response.Count
+			} else if response.Count != firstCount {
+				return nil, fmt.Errorf("postponed post count changed from %d to %d while fetching",
+					firstCount, response.Count)
+			}
 
 			allPosts = append(allPosts, response.Items)
 
@@ -152,9 +459,9 @@ func GetAllPostponedWallposts(vkUser *api.VK, domain string) ([]object.WallWallp
 			break
 		}
 
-		logging.Log.Warn().Int("attempt", retries+1).Msg("Retrying wallpost fetch due to error")
+		logger.Warn().Int("attempt", retries+1).Msg("Retrying wallpost fetch due to error")
 		if retries == maxRetries-1 {
-			logging.Log.Fatal().Err(err).Msg("Maximum retry attempts reached. Exiting...")
+			logger.Error().Err(err).Msg("Maximum retry attempts reached")
 			return nil, err
 		}
 
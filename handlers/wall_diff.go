@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/SevereCloud/vksdk/v2/object"
+	"github.com/rs/zerolog"
+)
+
+// WallpostChange holds two versions of the same postponed post, taken from consecutive storage snapshots.
+type WallpostChange struct {
+	Previous object.WallWallpost
+	Current  object.WallWallpost
+}
+
+// WallpostDiff describes the difference between two consecutive WallpostStorage snapshots.
+// Posts missing from the newer snapshot are split into Published (publication date has already passed)
+// and Deleted (publication date is still in the future, so the post was removed from the postponed list by hand).
+// Diffs reported by WallpostStorage have Deleted posts confirmed by fetching them by ID.
+// A post both rescheduled and edited is listed in both Rescheduled and Edited.
+type WallpostDiff struct {
+	Added       []object.WallWallpost
+	Published   []object.WallWallpost
+	Deleted     []object.WallWallpost
+	Rescheduled []WallpostChange
+	Edited      []WallpostChange
+}
+
+// IsEmpty reports whether the snapshots compared were identical.
+func (diff WallpostDiff) IsEmpty() bool {
+	return len(diff.Added) == 0 && len(diff.Published) == 0 && len(diff.Deleted) == 0 &&
+		len(diff.Rescheduled) == 0 && len(diff.Edited) == 0
+}
+
+// Log writes a summary of the diff to `logger`, listing posts affected by every kind of change.
+func (diff WallpostDiff) Log(logger zerolog.Logger) {
+	logger.Info().
+		Strs("added", wallpostLinks(diff.Added)).
+		Strs("published", wallpostLinks(diff.Published)).
+		Strs("deleted", wallpostLinks(diff.Deleted)).
+		Strs("rescheduled", wallpostChangeLinks(diff.Rescheduled)).
+		Strs("edited", wallpostChangeLinks(diff.Edited)).
+		Msg("WPStorage: Storage snapshot changed")
+}
+
+// wallpostKey identifies a post across snapshots.
+type wallpostKey struct {
+	ownerID int
+	id      int
+}
+
+// DiffWallposts compares two snapshots of postponed posts.
+// Posts are matched by owner and post ID. `now` is a UNIX timestamp used to tell published posts from deleted ones.
+// Order of posts in the resulting diff follows their order in the snapshots.
+func DiffWallposts(previous, current []object.WallWallpost, now int64) WallpostDiff {
+	var diff WallpostDiff
+
+	previousByKey := make(map[wallpostKey]object.WallWallpost, len(previous))
+	for _, post := range previous {
+		previousByKey[wallpostKey{post.OwnerID, post.ID}] = post
+	}
+	currentKeys := make(map[wallpostKey]struct{}, len(current))
+
+	for _, post := range current {
+		key := wallpostKey{post.OwnerID, post.ID}
+		currentKeys[key] = struct{}{}
+		previousPost, found := previousByKey[key]
+		if !found {
+			diff.Added = append(diff.Added, post)
+			continue
+		}
+		if previousPost.Date != post.Date {
+			diff.Rescheduled = append(diff.Rescheduled, WallpostChange{Previous: previousPost, Current: post})
+		}
+		if previousPost.Text != post.Text ||
+			!slices.Equal(getAttachmentIDs(previousPost), getAttachmentIDs(post)) {
+			diff.Edited = append(diff.Edited, WallpostChange{Previous: previousPost, Current: post})
+		}
+	}
+
+	for _, post := range previous {
+		if _, found := currentKeys[wallpostKey{post.OwnerID, post.ID}]; found {
+			continue
+		}
+		if int64(post.Date) <= now {
+			diff.Published = append(diff.Published, post)
+		} else {
+			diff.Deleted = append(diff.Deleted, post)
+		}
+	}
+
+	return diff
+}
+
+// getAttachmentIDs returns identifiers of every post attachment, in order, to detect changed attachments.
+func getAttachmentIDs(post object.WallWallpost) []string {
+	attachmentIDs := make([]string, 0, len(post.Attachments))
+	for _, attachment := range post.Attachments {
+		var attachmentID string
+		switch attachment.Type {
+		case "photo":
+			attachmentID = attachment.Photo.ToAttachment()
+		case "video":
+			attachmentID = attachment.Video.ToAttachment()
+		case "audio":
+			attachmentID = attachment.Audio.ToAttachment()
+		case "doc":
+			attachmentID = attachment.Doc.ToAttachment()
+		case "poll":
+			attachmentID = attachment.Poll.ToAttachment()
+		case "link":
+			attachmentID = "link" + attachment.Link.URL
+		default:
+			attachmentID = attachment.Type
+		}
+		attachmentIDs = append(attachmentIDs, attachmentID)
+	}
+	return attachmentIDs
+}
+
+// wallpostLinks formats posts as "wall{owner}_{id}" identifiers for logging.
+func wallpostLinks(posts []object.WallWallpost) []string {
+	links := make([]string, 0, len(posts))
+	for _, post := range posts {
+		links = append(links, fmt.Sprintf("wall%d_%d", post.OwnerID, post.ID))
+	}
+	return links
+}
+
+// wallpostChangeLinks formats changed posts as "wall{owner}_{id}" identifiers for logging.
+func wallpostChangeLinks(changes []WallpostChange) []string {
+	links := make([]string, 0, len(changes))
+	for _, change := range changes {
+		links = append(links, fmt.Sprintf("wall%d_%d", change.Current.OwnerID, change.Current.ID))
+	}
+	return links
+}
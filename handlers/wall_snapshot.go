@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/SevereCloud/vksdk/v2/object"
+)
+
+// wallpostSnapshot is the on-disk representation of WallpostStorage contents.
+type wallpostSnapshot struct {
+	Timestamp int64                 `json:"timestamp"`
+	WallPosts []object.WallWallpost `json:"wall_posts"`
+}
+
+// LoadSnapshot restores wall posts and their timestamp from the snapshot file, if one is configured and present.
+// Returns true if a snapshot was loaded. A missing snapshot file is not an error.
+// The loaded timestamp is kept as is, so CheckWallpostStorageNeedsUpdate still reports stale snapshots.
+func (wpStorage *WallpostStorage) LoadSnapshot() (bool, error) {
+	if wpStorage.snapshotPath == "" {
+		return false, nil
+	}
+	data, err := os.ReadFile(wpStorage.snapshotPath)
+	if errors.Is(err, os.ErrNotExist) {
+		wpStorage.logger.Info().Str("path", wpStorage.snapshotPath).Msg("WPStorage: No snapshot found")
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	var snapshot wallpostSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return false, err
+	}
+	wpStorage.mu.Lock()
+	wpStorage.wallPosts = snapshot.WallPosts
+	wpStorage.timestamp = snapshot.Timestamp
+	wpStorage.mu.Unlock()
+	wpStorage.logger.Info().Str("path", wpStorage.snapshotPath).Int("posts", len(snapshot.WallPosts)).
+		Int64("timestamp", snapshot.Timestamp).Msg("WPStorage: Snapshot loaded")
+	return true, nil
+}
+
+// SaveSnapshot writes current wall posts and timestamp to the snapshot file, if one is configured.
+// The snapshot is written atomically, so a crash never leaves a truncated snapshot.
+func (wpStorage *WallpostStorage) SaveSnapshot() error {
+	if wpStorage.snapshotPath == "" {
+		return nil
+	}
+	wpStorage.snapshotMu.Lock()
+	defer wpStorage.snapshotMu.Unlock()
+
+	wpStorage.mu.RLock()
+	data, err := json.Marshal(wallpostSnapshot{
+		Timestamp: wpStorage.timestamp,
+		WallPosts: wpStorage.wallPosts,
+	})
+	wpStorage.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(wpStorage.snapshotPath, data)
+}
+
+// writeFileAtomically writes data to a temporary file next to `path` and then renames it to `path`,
+// so a crash never leaves a truncated file behind.
+func writeFileAtomically(path string, data []byte) error {
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile.Name(), path)
+}
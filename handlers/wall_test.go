@@ -0,0 +1,349 @@
+package handlers
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/SevereCloud/vksdk/v2/object"
+	"github.com/rs/zerolog"
+)
+
+// fakeWallpostFetcher returns a new generation of posts on every fetch: fakePostCount posts,
+// all with the generation number as text.
+type fakeWallpostFetcher struct {
+	fetches atomic.Int32
+}
+
+const fakePostCount = 50
+
+func (fetcher *fakeWallpostFetcher) FetchPostponedWallposts() ([]object.WallWallpost, error) {
+	generation := fetcher.fetches.Add(1)
+	// Widens the window for concurrent callers to run into the in-flight fetch
+	time.Sleep(5 * time.Millisecond)
+	posts := make([]object.WallWallpost, fakePostCount)
+	for i := range posts {
+		posts[i] = object.WallWallpost{ID: i + 1, Date: int(time.Now().Unix()) + 3600, Text: strconv.Itoa(int(generation))}
+	}
+	return posts, nil
+}
+
+func (fetcher *fakeWallpostFetcher) FetchWallpostsByID(posts []object.WallWallpost) ([]object.WallWallpost, error) {
+	return nil, nil
+}
+
+// checkSnapshot fails the test unless posts are a single complete generation returned by fakeWallpostFetcher.
+func checkSnapshot(t *testing.T, posts []object.WallWallpost) {
+	if len(posts) == 0 {
+		return
+	}
+	if len(posts) != fakePostCount {
+		t.Errorf("got %d posts, want %d", len(posts), fakePostCount)
+		return
+	}
+	for i, post := range posts {
+		if post.ID != i+1 || post.Text != posts[0].Text {
+			t.Errorf("post %d is %d of generation %s, mixed with generation %s", i, post.ID, post.Text, posts[0].Text)
+			return
+		}
+	}
+}
+
+// expireStorage makes stored posts stale.
+func expireStorage(storage *WallpostStorage) {
+	storage.mu.Lock()
+	storage.timestamp = 0
+	storage.mu.Unlock()
+}
+
+func TestWallpostStorageFetchesOncePerStaleWindow(t *testing.T) {
+	const rounds = 5
+	const callers = 32
+
+	fetcher := &fakeWallpostFetcher{}
+	storage := NewWallpostStorage(fetcher, 3600, "", zerolog.Nop())
+	for round := 1; round <= rounds; round++ {
+		expireStorage(storage)
+		start := make(chan struct{})
+		var wg sync.WaitGroup
+		for range callers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-start
+				updateStorageIfStale(storage)
+				checkSnapshot(t, storage.GetWallposts())
+			}()
+		}
+		close(start)
+		wg.Wait()
+
+		if fetches := fetcher.fetches.Load(); fetches != int32(round) {
+			t.Fatalf("round %d: fetcher ran %d times in total, want %d", round, fetches, round)
+		}
+		if storage.CheckWallpostStorageNeedsUpdate() {
+			t.Fatalf("round %d: storage is stale after update", round)
+		}
+	}
+}
+
+func TestWallpostStorageReadersSeeCompleteSnapshots(t *testing.T) {
+	const updaters = 8
+	const readers = 16
+	const iterations = 20
+
+	fetcher := &fakeWallpostFetcher{}
+	storage := NewWallpostStorage(fetcher, 3600, "", zerolog.Nop())
+	var wg sync.WaitGroup
+	for range updaters {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range iterations {
+				if _, err := storage.UpdateWallpostStorage(); err != nil {
+					t.Errorf("updating storage: %v", err)
+				}
+			}
+		}()
+	}
+	for range readers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range iterations * 10 {
+				storage.CheckWallpostStorageNeedsUpdate()
+				checkSnapshot(t, storage.GetWallposts())
+			}
+		}()
+	}
+	wg.Wait()
+
+	if fetches := fetcher.fetches.Load(); fetches < 1 || fetches > updaters*iterations {
+		t.Errorf("fetcher ran %d times, want between 1 and %d", fetches, updaters*iterations)
+	}
+	checkSnapshot(t, storage.GetWallposts())
+	if got, want := storage.GetWallposts()[0].Text, strconv.Itoa(int(fetcher.fetches.Load())); got != want {
+		t.Errorf("storage holds generation %s, want the last one %s", got, want)
+	}
+}
+
+// scriptedWallpostFetcher returns postponed posts from `postponed`, one slice per fetch,
+// and posts found by ID from `byID`.
+type scriptedWallpostFetcher struct {
+	postponed [][]object.WallWallpost
+	byID      map[int]object.WallWallpost
+	byIDErr   error
+}
+
+func (fetcher *scriptedWallpostFetcher) FetchPostponedWallposts() ([]object.WallWallpost, error) {
+	posts := fetcher.postponed[0]
+	fetcher.postponed = fetcher.postponed[1:]
+	return posts, nil
+}
+
+func (fetcher *scriptedWallpostFetcher) FetchWallpostsByID(posts []object.WallWallpost) ([]object.WallWallpost, error) {
+	if fetcher.byIDErr != nil {
+		return nil, fetcher.byIDErr
+	}
+	var found []object.WallWallpost
+	for _, post := range posts {
+		if foundPost, ok := fetcher.byID[post.ID]; ok {
+			found = append(found, foundPost)
+		}
+	}
+	return found, nil
+}
+
+func wallpostIDs(posts []object.WallWallpost) []int {
+	ids := make([]int, 0, len(posts))
+	for _, post := range posts {
+		ids = append(ids, post.ID)
+	}
+	return ids
+}
+
+func TestWallpostStorageConfirmsDeletedPosts(t *testing.T) {
+	future := int(time.Now().Unix()) + 3600
+	posts := []object.WallWallpost{
+		{ID: 1, Date: future, PostType: "postpone"},
+		{ID: 2, Date: future, PostType: "postpone"}, // published with "publish now"
+		{ID: 3, Date: future, PostType: "postpone"}, // deleted
+		{ID: 4, Date: future, PostType: "postpone"}, // skipped while paging
+	}
+
+	tests := []struct {
+		name          string
+		byID          map[int]object.WallWallpost
+		byIDErr       error
+		wantStored    []int
+		wantPublished []int
+		wantDeleted   []int
+	}{
+		{
+			name: "confirmed",
+			byID: map[int]object.WallWallpost{
+				2: {ID: 2, Date: future - 3600, PostType: "post"},
+				4: posts[3],
+			},
+			wantStored:    []int{1, 4},
+			wantPublished: []int{2},
+			wantDeleted:   []int{3},
+		},
+		{
+			name:       "not confirmed",
+			byIDErr:    errors.New("network is down"),
+			wantStored: []int{1, 2, 3, 4},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fetcher := &scriptedWallpostFetcher{
+				postponed: [][]object.WallWallpost{posts, posts[:1]},
+				byID:      test.byID,
+				byIDErr:   test.byIDErr,
+			}
+			storage := NewWallpostStorage(fetcher, 3600, "", zerolog.Nop())
+			if _, err := storage.UpdateWallpostStorage(); err != nil {
+				t.Fatal(err)
+			}
+			diff, err := storage.UpdateWallpostStorage()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := wallpostIDs(storage.GetWallposts()); !slices.Equal(got, test.wantStored) {
+				t.Errorf("stored posts %v, want %v", got, test.wantStored)
+			}
+			if got := wallpostIDs(diff.Published); !slices.Equal(got, test.wantPublished) {
+				t.Errorf("published posts %v, want %v", got, test.wantPublished)
+			}
+			if got := wallpostIDs(diff.Deleted); !slices.Equal(got, test.wantDeleted) {
+				t.Errorf("deleted posts %v, want %v", got, test.wantDeleted)
+			}
+			if len(diff.Added) != 0 || len(diff.Rescheduled) != 0 || len(diff.Edited) != 0 {
+				t.Errorf("unexpected changes in diff %+v", diff)
+			}
+		})
+	}
+}
+
+// blockingWallpostFetcher returns no posts, each fetch waiting until `release` is closed.
+type blockingWallpostFetcher struct {
+	fetches atomic.Int32
+	started chan struct{}
+	release chan struct{}
+}
+
+func (fetcher *blockingWallpostFetcher) FetchPostponedWallposts() ([]object.WallWallpost, error) {
+	if fetcher.fetches.Add(1) == 1 {
+		close(fetcher.started)
+	}
+	<-fetcher.release
+	return nil, nil
+}
+
+func (fetcher *blockingWallpostFetcher) FetchWallpostsByID(posts []object.WallWallpost) ([]object.WallWallpost, error) {
+	return nil, nil
+}
+
+// fillStaleStorage fills the storage with a stale post, the way a snapshot of an earlier run does.
+func fillStaleStorage(storage *WallpostStorage) {
+	storage.mu.Lock()
+	storage.wallPosts = []object.WallWallpost{{ID: 1, Date: int(time.Now().Unix()) + 3600}}
+	storage.timestamp = time.Now().Unix() - 7200
+	storage.mu.Unlock()
+}
+
+// checkNoWait fails the test if updateStorageIfStale blocks on the fetcher.
+func checkNoWait(t *testing.T, storage *WallpostStorage) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		updateStorageIfStale(storage)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("request waited for the storage update")
+	}
+	if got := wallpostIDs(storage.GetWallposts()); !slices.Equal(got, []int{1}) {
+		t.Errorf("stored posts %v, want the stale ones [1]", got)
+	}
+}
+
+func TestWallpostStorageRequestsDontWaitForUpdates(t *testing.T) {
+	t.Run("in-flight update", func(t *testing.T) {
+		fetcher := &blockingWallpostFetcher{started: make(chan struct{}), release: make(chan struct{})}
+		storage := NewWallpostStorage(fetcher, 3600, "", zerolog.Nop())
+		fillStaleStorage(storage)
+		updated := make(chan struct{})
+		go func() {
+			defer close(updated)
+			if _, err := storage.UpdateWallpostStorage(); err != nil {
+				t.Errorf("updating storage: %v", err)
+			}
+		}()
+		<-fetcher.started
+
+		checkNoWait(t, storage)
+		close(fetcher.release)
+		<-updated
+		if fetches := fetcher.fetches.Load(); fetches != 1 {
+			t.Errorf("fetcher ran %d times, want 1", fetches)
+		}
+	})
+
+	t.Run("background refresh", func(t *testing.T) {
+		fetcher := &blockingWallpostFetcher{started: make(chan struct{}), release: make(chan struct{})}
+		storage := NewWallpostStorage(fetcher, 3600, "", zerolog.Nop())
+		fillStaleStorage(storage)
+		storage.refreshedInBackground.Store(true)
+
+		checkNoWait(t, storage)
+		close(fetcher.release)
+		if fetches := fetcher.fetches.Load(); fetches != 0 {
+			t.Errorf("fetcher ran %d times, want 0", fetches)
+		}
+	})
+}
+
+func TestWallpostStorageNotifiesListenersInOrder(t *testing.T) {
+	future := int(time.Now().Unix()) + 3600
+	post := func(id int) object.WallWallpost {
+		return object.WallWallpost{ID: id, Date: future, PostType: "postpone"}
+	}
+	fetcher := &scriptedWallpostFetcher{postponed: [][]object.WallWallpost{
+		{post(1)},
+		{post(1), post(2)},
+		{post(1), post(2), post(3)},
+	}}
+	storage := NewWallpostStorage(fetcher, 3600, "", zerolog.Nop())
+
+	release := make(chan struct{})
+	var added []int
+	storage.OnUpdate(func(diff WallpostDiff) {
+		<-release
+		// Registering a listener from a listener must not deadlock
+		storage.OnUpdate(func(WallpostDiff) {})
+		added = append(added, wallpostIDs(diff.Added)...)
+	})
+
+	// Updates return while the listener is still blocked
+	for range 3 {
+		if _, err := storage.UpdateWallpostStorage(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(release)
+	if !storage.WaitForListeners(time.Second) {
+		t.Fatal("listeners didn't handle queued diffs")
+	}
+	if want := []int{2, 3}; !slices.Equal(added, want) {
+		t.Errorf("listener got added posts %v, want %v", added, want)
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/alphatoasterous/otlozhka-bot/config"
+	"github.com/rs/zerolog"
+)
+
+// runICalendarServer serves the iCalendar file of postponed posts with `handler` on the configured address and path.
+// It blocks until ctx is cancelled, then shuts the server down gracefully.
+func runICalendarServer(ctx context.Context, iCalendarConfig config.ICalendarConfiguration, handler http.Handler,
+	logger zerolog.Logger) error {
+	if iCalendarConfig.Token == "" {
+		logger.Warn().Msg("iCalendar token is not set, the calendar is available to anyone reaching the server")
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(iCalendarConfig.Path, handler)
+	server := &http.Server{
+		Addr:              iCalendarConfig.Address,
+		Handler:           mux,
+		ReadHeaderTimeout: time.Second * 10,
+	}
+
+	logger.Info().Str("address", iCalendarConfig.Address).Str("path", iCalendarConfig.Path).
+		Msg("iCalendar server listening")
+	return serveHTTP(ctx, server)
+}
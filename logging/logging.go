@@ -3,9 +3,9 @@ package logging
 // Based on https://gist.github.com/panta/2530672ca641d953ae452ecb5ef79d7d
 
 import (
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"os"
 	"path"
 
@@ -14,12 +14,15 @@ import (
 	"gopkg.in/natefinch/lumberjack.v2"
 )
 
-// Configuration for logging
+// Logger is a zerolog logger along with log files it writes to, which are closed by Close.
+// Components of the bot take the embedded zerolog.Logger.
 type Logger struct {
-	*zerolog.Logger
+	zerolog.Logger
+
+	closers []io.Closer
 }
 
-// Configure sets up the logging framework
+// New sets up a logger writing to the console and/or a rolling log file, as configured.
 //
 // In production, the container logs will be collected and file logging should be disabled. However,
 // during development it's nicer to see logs as text and optionally write to a file when debugging
@@ -27,19 +30,24 @@ type Logger struct {
 //
 // The output log file will be located at /var/log/service-xyz/service-xyz.log and
 // will be rolled according to configuration set.
-func Configure(config config.ZerologConfiguration) *Logger {
+func New(config config.ZerologConfiguration) (*Logger, error) {
 	var writers []io.Writer
+	var closers []io.Closer
 
 	if config.ConsoleLoggingEnabled {
 		writers = append(writers, zerolog.ConsoleWriter{Out: os.Stderr})
 	}
 	if config.FileLoggingEnabled {
-		writers = append(writers, newRollingFile(config))
+		rollingFile, err := newRollingFile(config)
+		if err != nil {
+			return nil, err
+		}
+		writers = append(writers, rollingFile)
+		closers = append(closers, rollingFile)
 	}
 	mw := io.MultiWriter(writers...)
 
-	zerolog.SetGlobalLevel(zerolog.DebugLevel)
-	logger := zerolog.New(mw).With().Timestamp().Logger()
+	logger := zerolog.New(mw).Level(zerolog.DebugLevel).With().Timestamp().Logger()
 
 	logger.Info().
 		Bool("fileLogging", config.FileLoggingEnabled).
@@ -52,15 +60,23 @@ func Configure(config config.ZerologConfiguration) *Logger {
 		Msg("logging configured")
 
 	return &Logger{
-		Logger: &logger,
+		Logger:  logger,
+		closers: closers,
+	}, nil
+}
+
+// Close flushes and closes log files. The logger must not be used after Close.
+func (logger *Logger) Close() error {
+	var errs []error
+	for _, closer := range logger.closers {
+		errs = append(errs, closer.Close())
 	}
+	return errors.Join(errs...)
 }
 
-func newRollingFile(config config.ZerologConfiguration) io.Writer {
+func newRollingFile(config config.ZerologConfiguration) (*lumberjack.Logger, error) {
 	if err := os.MkdirAll(config.Directory, 0744); err != nil {
-		fmt.Printf("ERROR: can't create log directory: %v, path: %s\n", err, config.Directory)
-		log.Fatal("A fatal error has occured initializing zerolog.")
-		return nil
+		return nil, fmt.Errorf("can't create log directory %s: %w", config.Directory, err)
 	}
 
 	return &lumberjack.Logger{
@@ -68,13 +84,5 @@ func newRollingFile(config config.ZerologConfiguration) io.Writer {
 		MaxBackups: config.MaxBackups, // files
 		MaxSize:    config.MaxSize,    // megabytes
 		MaxAge:     config.MaxAge,     // days
-	}
-}
-
-var Log *Logger
-
-func init() {
-	// Setting up zerolog logger
-	zerologConfig := config.BotConfig.ZerologConfig
-	Log = Configure(zerologConfig)
+	}, nil
 }
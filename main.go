@@ -2,6 +2,13 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/SevereCloud/vksdk/v2/api"
 	"github.com/SevereCloud/vksdk/v2/events"
@@ -10,54 +17,261 @@ import (
 	"github.com/alphatoasterous/otlozhka-bot/config"
 	"github.com/alphatoasterous/otlozhka-bot/handlers"
 	"github.com/alphatoasterous/otlozhka-bot/logging"
+	"github.com/rs/zerolog"
 )
 
 func main() {
+	configFilename := flag.String("config", "config.toml", "Specify config filename")
+	flag.Usage = printUsage
+	flag.Parse()
 
-	logging.Log.Info().Msg("Starting up otlozhka-bot...")
-	// Setting up bot configuration
-	botConfig := config.BotConfig.Main
+	// Running a subcommand instead of the bot, if one is given
+	if args := flag.Args(); len(args) > 0 {
+		if err := runSubcommand(*configFilename, args); err != nil {
+			exitWithError(err)
+		}
+		return
+	}
+
+	// Setting up bot configuration and logging
+	cfg, err := loadConfigFile(*configFilename)
+	if err != nil {
+		exitWithError(err)
+	}
+	logger, err := logging.New(cfg.ZerologConfig)
+	if err != nil {
+		exitWithError(fmt.Errorf("setting up logging: %w", err))
+	}
+	configStore := config.NewStore(*configFilename, cfg)
+
+	logger.Info().Msg("Starting up otlozhka-bot...")
+	botConfig := cfg.Main
+
+	vkCommunity, vkUser := newAPIClients(botConfig, logger.Logger)
+
+	// Getting group information via community VK instance
+	group, err := api_utils.GetGroupInfo(vkCommunity)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to get group info")
+	}
+	domain := group.ScreenName
+	groupManagerIDs, err := api_utils.GetGroupManagerIDs(vkUser, domain)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to get group managers")
+	}
+
+	// Cancelled on SIGINT or SIGTERM to shut the bot down gracefully
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// Running handlers and background jobs, waited for on shutdown
+	tasks := &taskGroup{logger: logger.Logger}
+	// Reloading configuration on SIGHUP
+	tasks.Go(func() { reloadConfigOnSignal(ctx, configStore, logger.Logger) })
+
+	// Setting up wallpost storage
+	keepAlive := botConfig.StorageKeepAlive
+	wallpostStorage := handlers.NewWallpostStorage(handlers.NewVKWallpostFetcher(vkUser, domain, logger.Logger),
+		int64(keepAlive), botConfig.StorageSnapshotPath, logger.Logger)
+	if cfg.Notifications.Enabled {
+		wallpostStorage.OnUpdate(handlers.NewAuthorNotifier(configStore, vkCommunity, logger.Logger))
+		logger.Debug().Msg("Author notifications set up")
+	}
+	snapshotLoaded, err := wallpostStorage.LoadSnapshot()
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to load wallpost storage snapshot")
+	}
+	if snapshotLoaded {
+		// Serving posts from snapshot right away, while fresh posts are being fetched
+		tasks.Go(func() {
+			if _, err := wallpostStorage.UpdateWallpostStorage(); err != nil {
+				logger.Error().Err(err).Msg("Failed to update wallpost storage")
+			}
+		})
+	} else if _, err := wallpostStorage.UpdateWallpostStorage(); err != nil {
+		// Storage stays empty and gets updated again on the next request or background refresh
+		logger.Error().Err(err).Msg("Failed to update wallpost storage")
+	}
+	logger.Debug().Msg("Wallpost Storage instance set up")
+
+	// Setting up background wallpost storage refresh
+	if botConfig.StorageRefreshInterval > 0 {
+		refresher := handlers.NewWallpostRefresher(wallpostStorage,
+			time.Duration(botConfig.StorageRefreshInterval)*time.Second,
+			time.Duration(botConfig.StorageRefreshJitter)*time.Second,
+			time.Duration(botConfig.StorageRefreshMaxBackoff)*time.Second, logger.Logger)
+		tasks.Go(func() { refresher.Run(ctx) })
+		logger.Debug().Msg("Wallpost Storage background refresh set up")
+	}
+
+	// Setting up publication reminders
+	if remindersConfig := cfg.Reminders; remindersConfig.Enabled {
+		leadTimes := make([]time.Duration, 0, len(remindersConfig.LeadTimes))
+		for _, leadTime := range remindersConfig.LeadTimes {
+			leadTimes = append(leadTimes, time.Duration(leadTime)*time.Minute)
+		}
+		reminderScheduler := handlers.NewReminderScheduler(configStore, wallpostStorage, vkCommunity, leadTimes,
+			time.Duration(remindersConfig.CheckInterval)*time.Second, remindersConfig.SentRemindersPath, logger.Logger)
+		if err := reminderScheduler.LoadSentReminders(); err != nil {
+			logger.Error().Err(err).Msg("Failed to load sent reminders")
+		}
+		tasks.Go(func() { reminderScheduler.Run(ctx) })
+		logger.Debug().Msg("Publication reminders set up")
+	}
+
+	// Setting up daily schedule report
+	if scheduleConfig := cfg.Schedule; scheduleConfig.ReportEnabled {
+		scheduleReporter, err := handlers.NewScheduleReporter(configStore, wallpostStorage, vkCommunity,
+			scheduleConfig.ReportTime, scheduleConfig.ReportPeerIDs, logger.Logger)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("Failed to set up schedule report")
+		}
+		tasks.Go(func() { scheduleReporter.Run(ctx) })
+		logger.Debug().Msg("Schedule report set up")
+	}
+
+	// Setting up iCalendar server
+	if iCalendarConfig := cfg.ICalendar; iCalendarConfig.ServerEnabled {
+		handler := handlers.NewICalendarHTTPHandler(configStore, wallpostStorage, vkCommunity, iCalendarConfig.Token,
+			logger.Logger)
+		tasks.Go(func() {
+			if err := runICalendarServer(ctx, iCalendarConfig, handler, logger.Logger); err != nil {
+				logger.Error().Err(err).Msg("iCalendar server failed")
+			}
+		})
+		logger.Debug().Msg("iCalendar server set up")
+	}
+
+	// Passing NewMessageHandler to a MessageNew event.
+	// Every handler is tracked, so running handlers can finish their work on shutdown.
+	eventHandlers := events.NewFuncList()
+	eventHandlers.MessageNew(func(_ context.Context, obj events.MessageNewObject) {
+		tasks.Go(func() {
+			handlers.NewMessageHandler(obj, configStore, vkCommunity, groupManagerIDs, wallpostStorage, logger.Logger)
+		})
+	})
+	// Passing MessageEventHandler to a MessageEvent event, fired by keyboard callback buttons
+	eventHandlers.MessageEvent(func(_ context.Context, obj events.MessageEventObject) {
+		tasks.Go(func() {
+			handlers.MessageEventHandler(obj, configStore, vkCommunity, groupManagerIDs, wallpostStorage, logger.Logger)
+		})
+	})
+
+	switch botConfig.EventsMode {
+	case config.EventsModeCallback:
+		// Run Callback API server
+		logger.Info().Msg("otlozhka-bot set, running Callback API server")
+		if err := runCallbackServer(ctx, cfg.Callback, eventHandlers, logger.Logger); err != nil {
+			logger.Fatal().Err(err).Msg("Callback API server failed")
+		}
+	default:
+		// Setting up Long Poll
+		lp, err := longpoll.NewLongPoll(vkCommunity, group.ID)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("Failed to set up Long Poll")
+		}
+		lp.FuncList = *eventHandlers
+		logger.Debug().Msg("Long Poll set up")
+
+		// Run Bots Long Poll. It is shut down by cancelling ctx, same as lp.Shutdown does,
+		// which aborts the pending request, so errors after shutdown are expected.
+		logger.Info().Msg("otlozhka-bot set, running Long Poll")
+		if err := lp.RunWithContext(ctx); err != nil && ctx.Err() == nil {
+			logger.Fatal().Err(err).Msg("Long Poll failed")
+		}
+	}
+
+	shutdown(tasks, wallpostStorage, time.Duration(botConfig.ShutdownTimeout)*time.Second, logger)
+}
+
+// exitWithError reports an error preventing the bot from starting and exits.
+func exitWithError(err error) {
+	fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+	os.Exit(1)
+}
+
+// newAPIClients sets up VK API instances with community and user access.
+func newAPIClients(botConfig config.MainConfiguration, logger zerolog.Logger) (vkCommunity *api.VK, vkUser *api.VK) {
 
 	// Setting up community API instance
-	vkCommunity := api.NewVK(botConfig.CommunityToken)
+	vkCommunity = api.NewVK(botConfig.CommunityToken)
 	vkCommunity.Limit = botConfig.CommunityAPIRateLimit
 	vkCommunity.EnableMessagePack()
 	vkCommunity.EnableZstd()
-	logging.Log.Debug().Msg("Community API instance set up")
+	logger.Debug().Msg("Community API instance set up")
 
 	// Setting up user API instance
-	vkUser := api.NewVK(botConfig.UserToken)
+	vkUser = api.NewVK(botConfig.UserToken)
 	vkUser.EnableMessagePack()
 	vkUser.EnableZstd()
 	vkUser.Limit = botConfig.UserAPIRateLimit
-	logging.Log.Debug().Msg("User API instance set up")
+	logger.Debug().Msg("User API instance set up")
+	return vkCommunity, vkUser
+}
 
-	// Getting group information via community VK instance
-	group := api_utils.GetGroupInfo(vkCommunity)[0]
-	domain := group.ScreenName
-	groupManagerIDs := api_utils.GetGroupManagerIDs(vkUser, domain)
+// taskGroup tracks running handlers and background jobs, so they can finish their work on shutdown.
+// Unlike a bare sync.WaitGroup, it refuses to start new tasks once shutdown has begun.
+type taskGroup struct {
+	mu      sync.Mutex
+	closed  bool
+	running sync.WaitGroup
+	logger  zerolog.Logger
+}
 
-	// Setting up wallpost storage
-	keepAlive := botConfig.StorageKeepAlive
-	wallpostStorage := handlers.NewWallpostStorage(int64(keepAlive))
-	wallpostStorage.UpdateWallpostStorage(vkUser, domain)
-	logging.Log.Debug().Msg("Wallpost Storage instance set up")
+// Go runs task in a new goroutine, unless the group is already being waited for.
+func (tasks *taskGroup) Go(task func()) {
+	tasks.mu.Lock()
+	defer tasks.mu.Unlock()
+	if tasks.closed {
+		tasks.logger.Warn().Msg("Shutting down, task dropped")
+		return
+	}
+	tasks.running.Add(1)
+	go func() {
+		defer tasks.running.Done()
+		task()
+	}()
+}
 
-	// Setting up Long Poll
-	lp, err := longpoll.NewLongPoll(vkCommunity, group.ID)
-	if err != nil {
-		logging.Log.Fatal().Err(err)
+// Wait stops accepting new tasks and waits up to `timeout` for running ones.
+// Returns false if some tasks were still running when the timeout expired.
+func (tasks *taskGroup) Wait(timeout time.Duration) bool {
+	tasks.mu.Lock()
+	tasks.closed = true
+	tasks.mu.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		tasks.running.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return true
+	case <-time.After(timeout):
+		return false
 	}
-	logging.Log.Debug().Msg("Long Poll set up")
+}
 
-	// Passing NewMessageHandler to a MessageNew event
-	lp.MessageNew(func(_ context.Context, obj events.MessageNewObject) {
-		handlers.NewMessageHandler(obj, vkCommunity, vkUser, domain, groupManagerIDs, wallpostStorage)
-	})
+// shutdown waits up to `timeout` for running handlers and background jobs to finish,
+// and as long again for author notifications of storage updates to be sent,
+// then saves the wallpost storage snapshot and closes log files of `logger`.
+func shutdown(tasks *taskGroup, wallpostStorage *handlers.WallpostStorage, timeout time.Duration,
+	logger *logging.Logger) {
+	logger.Info().Dur("timeout", timeout).Msg("Shutting down, waiting for running handlers...")
+	if tasks.Wait(timeout) {
+		logger.Info().Msg("All handlers finished")
+	} else {
+		logger.Warn().Msg("Timed out waiting for running handlers")
+	}
+	if !wallpostStorage.WaitForListeners(timeout) {
+		logger.Warn().Msg("Timed out waiting for author notifications")
+	}
 
-	// Run Bots Long Poll
-	logging.Log.Info().Msg("otlozhka-bot set, running Long Poll")
-	if err := lp.Run(); err != nil {
-		logging.Log.Fatal().Err(err)
+	if err := wallpostStorage.SaveSnapshot(); err != nil {
+		logger.Error().Err(err).Msg("Failed to save wallpost storage snapshot")
+	}
+	logger.Info().Msg("otlozhka-bot stopped")
+	if err := logger.Close(); err != nil {
+		fmt.Printf("ERROR: Failed to close log files: %v\n", err)
 	}
 }
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/SevereCloud/vksdk/v2/object"
+	"github.com/alphatoasterous/otlozhka-bot/api_utils"
+	"github.com/alphatoasterous/otlozhka-bot/config"
+	"github.com/alphatoasterous/otlozhka-bot/handlers"
+	"github.com/rs/zerolog"
+)
+
+// listedTextLength is the length of post text excerpts printed by the "list-posts" subcommand.
+const listedTextLength = 60
+
+// fetchPostponedPosts fetches every postponed post of the community straight from VK, bypassing the storage.
+func fetchPostponedPosts(cfg *config.BotConfiguration, logger zerolog.Logger) ([]object.WallWallpost, error) {
+	vkCommunity, vkUser := newAPIClients(cfg.Main, logger)
+	group, err := api_utils.GetGroupInfo(vkCommunity)
+	if err != nil {
+		return nil, err
+	}
+	return handlers.GetAllPostponedWallposts(vkUser, group.ScreenName, logger)
+}
+
+// runListPostsCommand runs the "list-posts" subcommand, printing postponed posts sorted by publication date,
+// one per line, or as returned by VK API with -json.
+//
+//	otlozhka-bot [-config file] list-posts [-json]
+func runListPostsCommand(configFilename string, args []string) error {
+	flags := flag.NewFlagSet("list-posts", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "Print posts as returned by VK API, in JSON")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	cfg, logger, err := loadCommandConfig(configFilename)
+	if err != nil {
+		return err
+	}
+	defer closeCommandLogger(logger)
+	loc, err := time.LoadLocation(cfg.MessageBuilder.Timezone)
+	if err != nil {
+		return fmt.Errorf("loading timezone: %w", err)
+	}
+	posts, err := fetchPostponedPosts(cfg, logger.Logger)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(posts)
+	}
+	slices.SortStableFunc(posts, func(a, b object.WallWallpost) int { return a.Date - b.Date })
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "DATE\tLINK\tAUTHOR\tTEXT")
+	for _, post := range posts {
+		author := "-"
+		if post.SignerID > 0 {
+			author = fmt.Sprintf("id%d", post.SignerID)
+		}
+		fmt.Fprintf(writer, "%s\tvk.com/wall%d_%d\t%s\t%s\n",
+			time.Unix(int64(post.Date), 0).In(loc).Format("2006-01-02 15:04"), post.OwnerID, post.ID, author,
+			api_utils.GetTextExcerpt(strings.Join(strings.Fields(post.Text), " "), listedTextLength))
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	fmt.Printf("\nPostponed posts: %d\n", len(posts))
+	return nil
+}
+
+// runCalendarCommand runs the "calendar" subcommand, printing the calendar of postponed posts
+// the same way the bot sends it to managers. Arguments filter the calendar as in the chat command,
+// e.g. "завтра", "20.10-27.10" or "@id123".
+//
+//	otlozhka-bot [-config file] calendar [filter]
+func runCalendarCommand(configFilename string, args []string) error {
+	flags := flag.NewFlagSet("calendar", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	cfg, logger, err := loadCommandConfig(configFilename)
+	if err != nil {
+		return err
+	}
+	defer closeCommandLogger(logger)
+	timezone := cfg.MessageBuilder.Timezone
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return fmt.Errorf("loading timezone: %w", err)
+	}
+	filter, err := handlers.ParseCalendarFilter(strings.ToLower(strings.Join(flags.Args(), " ")), time.Now(), loc)
+	if err != nil {
+		return fmt.Errorf("parsing calendar filter: %w", err)
+	}
+	posts, err := fetchPostponedPosts(cfg, logger.Logger)
+	if err != nil {
+		return err
+	}
+
+	calendar, err := api_utils.GetFormattedCalendar(posts, timezone, filter)
+	if err != nil {
+		return fmt.Errorf("formatting calendar: %w", err)
+	}
+	if calendar == "" {
+		calendar = "No postponed posts found"
+	}
+	fmt.Println(calendar)
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+
+	"github.com/alphatoasterous/otlozhka-bot/config"
+	"github.com/rs/zerolog"
+)
+
+// reloadConfigOnSignal reloads the configuration held by `configStore` every time the bot gets SIGHUP,
+// until ctx is cancelled. Texts, regular expressions, message formats, timezone, chats, keyboard and so on
+// take effect right away; settings read once on startup are reported as needing a restart.
+// If the reloaded configuration is invalid, errors are logged to `logger` and the current configuration is kept.
+func reloadConfigOnSignal(ctx context.Context, configStore *config.Store, logger zerolog.Logger) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			previous, reloaded, err := configStore.Reload()
+			if err != nil {
+				logger.Error().Err(err).Msg("Failed to reload configuration, keeping the current one")
+				continue
+			}
+			logger.Info().Strs("changed", config.ChangedSections(previous, reloaded)).
+				Msg("Configuration reloaded")
+			if restart := getRestartRequiredSettings(previous, reloaded); len(restart) > 0 {
+				logger.Warn().Strs("settings", restart).
+					Msg("Some changed settings are only applied on restart")
+			}
+		}
+	}
+}
+
+// getRestartRequiredSettings lists changed settings which are only read on startup,
+// such as tokens, logging, servers and background jobs.
+func getRestartRequiredSettings(previous, reloaded *config.BotConfiguration) []string {
+	settings := []struct {
+		name               string
+		previous, reloaded any
+	}{
+		{"Main", previous.Main, reloaded.Main},
+		{"ZerologConfig", previous.ZerologConfig, reloaded.ZerologConfig},
+		{"Callback", previous.Callback, reloaded.Callback},
+		// Disabling notifications and reminders takes effect right away, enabling them needs a restart
+		{"Notifications.Enabled", previous.Notifications.Enabled, reloaded.Notifications.Enabled},
+		{"Reminders.Enabled", previous.Reminders.Enabled, reloaded.Reminders.Enabled},
+		{"Reminders.LeadTimes", previous.Reminders.LeadTimes, reloaded.Reminders.LeadTimes},
+		{"Reminders.CheckInterval", previous.Reminders.CheckInterval, reloaded.Reminders.CheckInterval},
+		{"Reminders.SentRemindersPath", previous.Reminders.SentRemindersPath, reloaded.Reminders.SentRemindersPath},
+		{"Schedule.ReportEnabled", previous.Schedule.ReportEnabled, reloaded.Schedule.ReportEnabled},
+		{"Schedule.ReportTime", previous.Schedule.ReportTime, reloaded.Schedule.ReportTime},
+		{"Schedule.ReportPeerIDs", previous.Schedule.ReportPeerIDs, reloaded.Schedule.ReportPeerIDs},
+		{"ICalendar.ServerEnabled", previous.ICalendar.ServerEnabled, reloaded.ICalendar.ServerEnabled},
+		{"ICalendar.Address", previous.ICalendar.Address, reloaded.ICalendar.Address},
+		{"ICalendar.Path", previous.ICalendar.Path, reloaded.ICalendar.Path},
+		{"ICalendar.Token", previous.ICalendar.Token, reloaded.ICalendar.Token},
+	}
+	var changed []string
+	for _, setting := range settings {
+		if !reflect.DeepEqual(setting.previous, setting.reloaded) {
+			changed = append(changed, setting.name)
+		}
+	}
+	return changed
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/alphatoasterous/otlozhka-bot/config"
+	"github.com/alphatoasterous/otlozhka-bot/logging"
+)
+
+// subcommand is an operator command run instead of the bot, named by the first command line argument.
+type subcommand struct {
+	name        string
+	description string
+	run         func(configFilename string, args []string) error
+}
+
+// subcommands lists every subcommand, in order of the usage message.
+var subcommands = []subcommand{
+	{"init-config", "write a commented default configuration file", runInitConfigCommand},
+	{"check-config", "validate the configuration file", runCheckConfigCommand},
+	{"whoami", "show the community and resolved managers, checking both tokens", runWhoamiCommand},
+	{"list-posts", "fetch and print postponed posts", runListPostsCommand},
+	{"calendar", "print the calendar of postponed posts, as sent to managers", runCalendarCommand},
+	{"export", "export postponed posts as CSV or JSON", runExportCommand},
+}
+
+// runSubcommand runs the subcommand named by args[0] with the rest of arguments.
+func runSubcommand(configFilename string, args []string) error {
+	for _, command := range subcommands {
+		if command.name != args[0] {
+			continue
+		}
+		err := command.run(configFilename, args[1:])
+		// Usage is already printed by the subcommand flag set
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	return fmt.Errorf("unknown command %q, run with -h to list commands", args[0])
+}
+
+// printUsage describes flags and subcommands of the binary.
+func printUsage() {
+	output := flag.CommandLine.Output()
+	fmt.Fprintf(output, "Usage: %s [-config file] [command] [arguments]\n\n", os.Args[0])
+	fmt.Fprintln(output, "Runs the bot, unless a command is given.")
+	fmt.Fprintln(output, "\nFlags:")
+	flag.PrintDefaults()
+	fmt.Fprintln(output, "\nCommands:")
+	writer := tabwriter.NewWriter(output, 0, 0, 2, ' ', 0)
+	for _, command := range subcommands {
+		fmt.Fprintf(writer, "  %s\t%s\n", command.name, command.description)
+	}
+	writer.Flush()
+	fmt.Fprintln(output, "\nRun a command with -h to list its arguments.")
+}
+
+// loadConfigFile loads the configuration file for the bot or a subcommand. A missing file is never created,
+// so a mistyped path doesn't leave a stray file behind; the error tells to create it with init-config instead.
+func loadConfigFile(configFilename string) (*config.BotConfiguration, error) {
+	cfg, err := config.Load(configFilename)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w; run otlozhka-bot init-config to create it", err)
+	}
+	return cfg, err
+}
+
+// loadCommandConfig loads the configuration file for a subcommand and sets up logging.
+// The subcommand closes the returned logger with closeCommandLogger once it's done.
+func loadCommandConfig(configFilename string) (*config.BotConfiguration, *logging.Logger, error) {
+	cfg, err := loadConfigFile(configFilename)
+	if err != nil {
+		return nil, nil, err
+	}
+	logger, err := logging.New(cfg.ZerologConfig)
+	if err != nil {
+		return nil, nil, fmt.Errorf("setting up logging: %w", err)
+	}
+	return cfg, logger, nil
+}
+
+// closeCommandLogger closes log files of a subcommand logger.
+func closeCommandLogger(logger *logging.Logger) {
+	if err := logger.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: Failed to close log files: %v\n", err)
+	}
+}
@@ -2,8 +2,12 @@ package utils
 
 import (
 	"math/rand"
+	"regexp"
+	"regexp/syntax"
 	"strconv"
+	"strings"
 	"time"
+	"unicode"
 )
 
 func UnixToTime(unixTime int64, location *time.Location) time.Time {
@@ -32,3 +36,60 @@ func GetRandomItemFromStrArray(arr []string) string {
 	// I used to roll the dice.
 	return arr[rand.Intn(len(arr))]
 }
+
+// GetRegexExample builds a short example string matched by the regular expression `re`.
+// It takes the first alternative of every alternation, the first rune of every character class
+// and the minimal number of repetitions, so "отложк[ауе]" gives "отложка".
+// Returns an empty string if the expression cannot be parsed.
+func GetRegexExample(re *regexp.Regexp) string {
+	parsed, err := syntax.Parse(re.String(), syntax.Perl)
+	if err != nil {
+		return ""
+	}
+	var example strings.Builder
+	writeRegexExample(&example, parsed.Simplify())
+	return example.String()
+}
+
+// writeRegexExample writes an example string matched by the parsed regular expression `re` to `example`.
+func writeRegexExample(example *strings.Builder, re *syntax.Regexp) {
+	switch re.Op {
+	case syntax.OpLiteral:
+		if re.Flags&syntax.FoldCase != 0 {
+			example.WriteString(strings.ToLower(string(re.Rune)))
+		} else {
+			example.WriteString(string(re.Rune))
+		}
+	case syntax.OpCharClass:
+		example.WriteRune(getCharClassExample(re.Rune))
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		example.WriteRune('x')
+	case syntax.OpCapture, syntax.OpPlus:
+		writeRegexExample(example, re.Sub[0])
+	case syntax.OpRepeat:
+		for i := 0; i < re.Min; i++ {
+			writeRegexExample(example, re.Sub[0])
+		}
+	case syntax.OpConcat:
+		for _, sub := range re.Sub {
+			writeRegexExample(example, sub)
+		}
+	case syntax.OpAlternate:
+		writeRegexExample(example, re.Sub[0])
+	}
+}
+
+// getCharClassExample picks a rune from character class ranges, preferring lowercase letters.
+func getCharClassExample(ranges []rune) rune {
+	for i := 0; i+1 < len(ranges); i += 2 {
+		for r := ranges[i]; r <= ranges[i+1] && r < ranges[i]+256; r++ {
+			if unicode.IsLower(r) {
+				return r
+			}
+		}
+	}
+	if len(ranges) == 0 {
+		return 'x'
+	}
+	return unicode.ToLower(ranges[0])
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/alphatoasterous/otlozhka-bot/api_utils"
+)
+
+// runWhoamiCommand runs the "whoami" subcommand, printing the community the community token belongs to,
+// the user the user token belongs to, and community managers the bot takes commands from.
+// Each part is printed even if another one fails, so token problems can be told apart.
+//
+//	otlozhka-bot [-config file] whoami
+func runWhoamiCommand(configFilename string, args []string) error {
+	flags := flag.NewFlagSet("whoami", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	cfg, logger, err := loadCommandConfig(configFilename)
+	if err != nil {
+		return err
+	}
+	defer closeCommandLogger(logger)
+	vkCommunity, vkUser := newAPIClients(cfg.Main, logger.Logger)
+
+	group, err := api_utils.GetGroupInfo(vkCommunity)
+	if err != nil {
+		return fmt.Errorf("community token: %w", err)
+	}
+	fmt.Printf("Community: %s (vk.com/%s, club%d)\n", group.Name, group.ScreenName, group.ID)
+
+	var errs []error
+	users, err := vkUser.UsersGet(nil)
+	switch {
+	case err != nil:
+		errs = append(errs, fmt.Errorf("user token: %w", err))
+	case len(users) > 0:
+		fmt.Printf("User token: %s %s (id%d)\n", users[0].FirstName, users[0].LastName, users[0].ID)
+	}
+
+	managerIDs, err := api_utils.GetGroupManagerIDs(vkUser, group.ScreenName)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("managers: %w", err))
+		return errors.Join(errs...)
+	}
+	managerNames, err := api_utils.GetUserNames(vkCommunity, managerIDs)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("manager names: %w", err))
+	}
+	fmt.Printf("Managers: %d\n", len(managerIDs))
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, id := range managerIDs {
+		fmt.Fprintf(writer, "  id%d\t%s\n", id, managerNames[id])
+	}
+	if err := writer.Flush(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}